@@ -0,0 +1,111 @@
+package app
+
+import (
+	"autossh/src/utils"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+const (
+	ForwardAgentYes = "yes"
+	ForwardAgentAsk = "ask"
+	ForwardAgentNo  = "no"
+)
+
+var (
+	// 已注册 agent 转发通道处理的连接，连接池中的连接会被多个会话复用
+	agentForwardMutex   sync.Mutex
+	agentForwardClients = make(map[*ssh.Client]bool)
+)
+
+// ForwardAgent 选项：true/yes 直接转发，ask 每次连接前确认，默认不转发
+// 分组标记为 untrusted 时，即使配置为 yes 也需要确认
+func (server *Server) forwardAgentMode() string {
+	mode := ForwardAgentNo
+	if val, ok := server.Options["ForwardAgent"]; ok {
+		if s, ok := val.(string); ok && strings.EqualFold(strings.TrimSpace(s), ForwardAgentAsk) {
+			mode = ForwardAgentAsk
+		} else if b, ok := toBool(val); ok && b {
+			mode = ForwardAgentYes
+		}
+	}
+
+	if mode == ForwardAgentYes && server.group != nil && server.group.Untrusted {
+		mode = ForwardAgentAsk
+	}
+	return mode
+}
+
+// 在会话上启用 ssh-agent 转发，返回是否已启用
+// 远程主机上的 root 用户可借此使用本地密钥，因此不受信任的主机需要确认
+func (server *Server) forwardAgent(client *ssh.Client, session *ssh.Session) (bool, error) {
+	mode := server.forwardAgentMode()
+	if mode == ForwardAgentNo {
+		return false, nil
+	}
+
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return false, errors.New("未检测到 ssh-agent（SSH_AUTH_SOCK 为空）")
+	}
+
+	if mode == ForwardAgentAsk && !confirmAgentForwarding(server) {
+		return false, nil
+	}
+
+	if err := registerAgentForwarding(client, socket); err != nil {
+		return false, fmt.Errorf("注册 agent 转发失败: %w", err)
+	}
+	if err := agent.RequestAgentForwarding(session); err != nil {
+		return false, fmt.Errorf("服务器拒绝 agent 转发: %w", err)
+	}
+	return true, nil
+}
+
+// 每个连接只能注册一次 auth-agent 通道处理，之后的会话只需各自请求转发
+func registerAgentForwarding(client *ssh.Client, socket string) error {
+	agentForwardMutex.Lock()
+	defer agentForwardMutex.Unlock()
+
+	if agentForwardClients[client] {
+		return nil
+	}
+	if err := agent.ForwardToRemote(client, socket); err != nil {
+		return err
+	}
+	agentForwardClients[client] = true
+
+	go func() {
+		_ = client.Wait()
+		agentForwardMutex.Lock()
+		delete(agentForwardClients, client)
+		agentForwardMutex.Unlock()
+	}()
+	return nil
+}
+
+// 询问是否向该服务器转发 ssh-agent，非交互终端中视为拒绝
+func confirmAgentForwarding(server *Server) bool {
+	if !terminal.IsTerminal(int(os.Stdin.Fd())) {
+		fmt.Fprintf(os.Stderr, "⚠️  %s 需要确认 agent 转发，非交互模式下已跳过\n", server.Name)
+		return false
+	}
+
+	fmt.Fprintf(os.Stderr, "⚠️  服务器 %s (%s) 上的管理员可以使用你的 ssh-agent 身份。\n", server.Name, server.address())
+	fmt.Fprint(os.Stderr, "❓ 确认转发本地 ssh-agent 吗？(yes/no): ")
+
+	var answer string
+	utils.Scanln(&answer)
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "yes", "y":
+		return true
+	}
+	return false
+}
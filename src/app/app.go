@@ -19,9 +19,13 @@ var (
 	h                        bool
 	upgrade                  bool
 	cp                       bool
+	tunnel                   bool
+	execMode                 bool
+	runScript                bool
 	debug                    bool
 	perf                     bool // 性能监控标志
 	insecureSkipHostKeyCheck bool
+	cliForwards              []Forward // 命令行指定的端口转发
 )
 
 func defaultConfigFilePath() string {
@@ -52,6 +56,9 @@ func Run() {
 	fs.BoolVar(&perf, "perf", false, "启用性能监控")
 	fs.BoolVar(&insecureSkipHostKeyCheck, "insecure", false, "跳过 SSH HostKey 校验（不安全）")
 	fs.BoolVar(&insecureSkipHostKeyCheck, "skip-host-key-check", false, "跳过 SSH HostKey 校验（不安全）")
+	fs.Var(forwardFlag{parse: parseLocalForward}, "L", "本地端口转发 [bind_address:]port:host:hostport")
+	fs.Var(forwardFlag{parse: parseRemoteForward}, "R", "远程端口转发 [bind_address:]port:host:hostport")
+	fs.Var(forwardFlag{parse: parseDynamicForward}, "D", "动态端口转发（SOCKS5） [bind_address:]port")
 
 	if err := fs.Parse(os.Args[1:]); err != nil {
 		fs.Usage()
@@ -60,8 +67,14 @@ func Run() {
 
 	upgrade = false
 	cp = false
+	tunnel = false
+	execMode = false
+	runScript = false
 	defaultServer = ""
 	var cpArgs []string
+	var tunnelArgs []string
+	var execArgs []string
+	var runArgs []string
 
 	if len(fs.Args()) > 0 {
 		arg := fs.Args()[0]
@@ -71,8 +84,34 @@ func Run() {
 		case "cp":
 			cp = true
 			cpArgs = fs.Args()[1:]
+		case "tunnel":
+			tunnel = true
+			tunnelArgs = fs.Args()[1:]
+		case "exec":
+			execMode = true
+			execArgs = fs.Args()[1:]
+		case "run":
+			runScript = true
+			runArgs = fs.Args()[1:]
 		default:
 			defaultServer = arg
+
+			// 服务器之后仍可跟选项，如 autossh jump -D 1080
+			rest := fs.Args()[1:]
+			if len(rest) > 0 && strings.HasPrefix(rest[0], "-") {
+				if err := fs.Parse(rest); err != nil {
+					fs.Usage()
+					os.Exit(2)
+				}
+				rest = fs.Args()
+			}
+
+			// autossh <alias> <command...>
+			if len(rest) > 0 {
+				execMode = true
+				execArgs = append([]string{arg, "--"}, rest...)
+				defaultServer = ""
+			}
 		}
 	}
 
@@ -97,7 +136,10 @@ func Run() {
 		stopTimer = utils.StartTimer("app_startup")
 	}
 
-	utils.Info("AutoSSH 启动中...")
+	// 执行命令时标准输出只包含远程命令的输出
+	if !execMode && !runScript {
+		utils.Info("AutoSSH 启动中...")
+	}
 
 	if v {
 		showVersion()
@@ -107,6 +149,12 @@ func Run() {
 		showUpgrade()
 	} else if cp {
 		showCp(c, cpArgs)
+	} else if tunnel {
+		showTunnel(c, tunnelArgs)
+	} else if execMode {
+		showExec(c, execArgs)
+	} else if runScript {
+		showRun(c, runArgs)
 	} else {
 		if perf && stopTimer != nil {
 			stopTimer()
@@ -129,15 +177,43 @@ func usage(w io.Writer) {
   -debug                启用调试模式
   -perf                 启用性能监控
   --insecure            跳过 SSH HostKey 校验（不安全）
+  -L [bind:]port:host:hostport
+                        本地端口转发，可重复指定；任意一端可写Unix套接字路径
+  -R [bind:]port:host:hostport
+                        远程端口转发（服务器端口 -> 本地地址），可重复指定
+  -D [bind:]port        动态端口转发，在本地提供经服务器出口的SOCKS5代理
 
 命令:
   upgrade               检查并下载最新版本
   cp                    复制配置文件
+  tunnel <服务器> [-L/-R/-D ...]
+                        仅建立端口转发（不打开Shell），断线后自动重连
+  exec [-t] [-n] [--sudo] <服务器> -- <命令>
+                        执行远程命令并以其退出码退出（-t 分配PTY，-n 不转发标准输入，
+                        --sudo 以 sudo 执行并自动输入 sudo_password，命令由登录shell以
+                        "$SHELL" -c 重新解析）
+  exec -g <分组前缀> | -s <服务器,...> [-p 并发数] -- <命令>
+                        在多台服务器上并行执行命令并汇总结果
+  exec --output json|jsonl ...
+                        以JSON输出每台服务器的stdout、stderr、退出码、耗时与错误分类
+  run [-t] [-stream] [--sudo] <服务器> <脚本> [参数...]
+                        上传本地脚本到服务器临时目录执行，结束后删除（-stream 通过标准输入执行）
 
 示例:
   autossh              显示服务器列表
   autossh 1            连接到编号为1的服务器
   autossh server1      连接到别名为server1的服务器
+  autossh -L 5432:db.internal:5432 jump  连接跳板机并转发数据库端口
+  autossh -L /tmp/docker.sock:/var/run/docker.sock dev01  转发服务器的Docker套接字
+  autossh -R 8080:localhost:3000 staging  将本地开发服务暴露到服务器8080端口
+  autossh -D 1080 jump  经跳板机访问内网（浏览器设置SOCKS5代理 127.0.0.1:1080）
+  autossh tunnel db01 -L 5432:127.0.0.1:5432  保持数据库隧道，断线自动重连
+  autossh exec web01 -- df -h  在web01上执行命令
+  autossh web01 uptime  同上，省略 exec
+  autossh exec -g t -p 10 -- uptime  在分组t的所有服务器上执行
+  autossh exec --output jsonl -g t -- uptime  供CI等自动化工具解析
+  autossh run web01 ./cleanup.sh --days 7  在web01上执行本地脚本
+  autossh exec --sudo -g t -- systemctl restart nginx  以 root 权限批量执行
   autossh -c /path/to/config.json 使用指定配置文件
   autossh -debug       启用调试模式
   autossh -perf        启用性能监控
@@ -19,6 +19,7 @@ type Config struct {
 	Servers    []*Server              `json:"servers"`
 	Groups     []*Group               `json:"groups"`
 	Options    map[string]interface{} `json:"options"`
+	Proxy      *Proxy                 `json:"proxy,omitempty"`
 
 	// 服务器map索引，可通过编号、别名快速定位到某一个服务器
 	serverIndex map[string]ServerIndex
@@ -31,17 +32,25 @@ type Config struct {
 }
 
 type Group struct {
-	GroupName string   `json:"group_name"`
-	Prefix    string   `json:"prefix"`
-	Servers   []Server `json:"servers"`
-	Collapse  bool     `json:"collapse"`
-	Proxy     *Proxy   `json:"proxy"`
+	GroupName    string   `json:"group_name"`
+	Prefix       string   `json:"prefix"`
+	Servers      []Server `json:"servers"`
+	Collapse     bool     `json:"collapse"`
+	Proxy        *Proxy   `json:"proxy"`
+	ProxyCommand string   `json:"proxy_command,omitempty"`
+	Untrusted    bool     `json:"untrusted,omitempty"`
 }
 
 type ProxyType string
 
 const (
-	ProxyTypeSocks5 ProxyType = "SOCKS5"
+	ProxyTypeSocks5  ProxyType = "SOCKS5"
+	ProxyTypeSocks4  ProxyType = "SOCKS4"
+	ProxyTypeSocks4a ProxyType = "SOCKS4A"
+	ProxyTypeHttp    ProxyType = "HTTP"
+	ProxyTypeHttps   ProxyType = "HTTPS"
+	// 显式禁用代理，用于覆盖分组或全局代理
+	ProxyTypeNone ProxyType = "NONE"
 )
 
 type Proxy struct {
@@ -84,6 +93,7 @@ func (cfg *Config) createServerIndex() {
 	for i := range cfg.Servers {
 		server := cfg.Servers[i]
 		server.Format()
+		server.cfg = cfg
 		index := strconv.Itoa(i + 1)
 
 		if _, ok := cfg.serverIndex[index]; ok {
@@ -109,6 +119,7 @@ func (cfg *Config) createServerIndex() {
 			server.Format()
 			server.groupName = group.GroupName
 			server.group = group
+			server.cfg = cfg
 			index := group.Prefix + strconv.Itoa(j+1)
 
 			if _, ok := cfg.serverIndex[index]; ok {
@@ -0,0 +1,333 @@
+package app
+
+import (
+	"autossh/src/utils"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 批量执行的目标服务器，label 用于输出前缀，优先使用别名
+type execTarget struct {
+	label  string
+	server *Server
+}
+
+// exec 的输出格式
+const (
+	ExecOutputText  = "text"
+	ExecOutputJSON  = "json"
+	ExecOutputJSONL = "jsonl"
+)
+
+// 单台服务器的执行结果，stdout/stderr 仅在JSON输出时收集
+type execResult struct {
+	target   execTarget
+	code     int
+	err      error
+	start    time.Time
+	duration time.Duration
+	stdout   string
+	stderr   string
+}
+
+// 供自动化解析的执行结果
+type ExecReport struct {
+	Server     string    `json:"server"`
+	Name       string    `json:"name"`
+	Host       string    `json:"host"`
+	Command    string    `json:"command"`
+	ExitCode   int       `json:"exit_code"`
+	Stdout     string    `json:"stdout"`
+	Stderr     string    `json:"stderr"`
+	StartedAt  time.Time `json:"started_at"`
+	DurationMs int64     `json:"duration_ms"`
+	Error      string    `json:"error,omitempty"`
+	ErrorType  string    `json:"error_type,omitempty"`
+}
+
+func (result execResult) report(command string) ExecReport {
+	report := ExecReport{
+		Server:     result.target.label,
+		Name:       result.target.server.Name,
+		Host:       result.target.server.address(),
+		Command:    command,
+		ExitCode:   result.code,
+		Stdout:     result.stdout,
+		Stderr:     result.stderr,
+		StartedAt:  result.start,
+		DurationMs: result.duration.Milliseconds(),
+	}
+	if result.err != nil {
+		report.Error = result.err.Error()
+		report.ErrorType = errorType(result.err)
+	}
+	return report
+}
+
+// 按分组（前缀或组名）或逗号分隔的编号/别名列表选择服务器
+func (cfg *Config) execTargets(group string, selection string) ([]execTarget, error) {
+	var targets []execTarget
+	seen := make(map[*Server]bool)
+	add := func(label string, server *Server) {
+		if seen[server] {
+			return
+		}
+		seen[server] = true
+		if server.Alias != "" {
+			label = server.Alias
+		}
+		targets = append(targets, execTarget{label: label, server: server})
+	}
+
+	if group != "" {
+		found := false
+		for _, g := range cfg.Groups {
+			if g.Prefix != group && g.GroupName != group {
+				continue
+			}
+			found = true
+			for j := range g.Servers {
+				add(g.Prefix+strconv.Itoa(j+1), &g.Servers[j])
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("分组 %s 不存在", group)
+		}
+	}
+
+	if selection != "" {
+		for _, key := range strings.Split(selection, ",") {
+			key = strings.TrimSpace(key)
+			if key == "" {
+				continue
+			}
+			index, ok := cfg.serverIndex[key]
+			if !ok {
+				return nil, fmt.Errorf("服务器 %s 不存在", key)
+			}
+			add(key, index.server)
+		}
+	}
+
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("没有匹配的服务器")
+	}
+	return targets, nil
+}
+
+// 使用固定数量的工作协程执行，结果顺序与 targets 一致
+func execParallel(targets []execTarget, parallel int, run func(target execTarget) execResult) []execResult {
+	if parallel <= 0 {
+		parallel = 1
+	}
+
+	results := make([]execResult, len(targets))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < parallel && w < len(targets); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = run(targets[i])
+			}
+		}()
+	}
+
+	for i := range targets {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
+
+// 在一组服务器上执行命令，输出按行加上服务器前缀，最后打印汇总表
+func execBatch(targets []execTarget, ef execFlags) int {
+	if ef.output == ExecOutputJSON || ef.output == ExecOutputJSONL {
+		return execBatchJSON(targets, ef)
+	}
+
+	var mu sync.Mutex
+	width := 0
+	for _, target := range targets {
+		if l := utils.ZhLen(target.label); l > width {
+			width = l
+		}
+	}
+
+	results := execParallel(targets, ef.parallel, func(target execTarget) execResult {
+		prefix := "[" + target.label + "]" + strings.Repeat(" ", width-utils.ZhLen(target.label)) + " "
+		stdout := newPrefixWriter(os.Stdout, &mu, prefix)
+		stderr := newPrefixWriter(os.Stderr, &mu, "\033[31m"+prefix+"\033[0m")
+
+		result := execResult{target: target, start: time.Now()}
+		result.code, result.err = target.server.Exec(ExecOptions{
+			Command: ef.command,
+			Sudo:    ef.sudo,
+			Stdout:  stdout,
+			Stderr:  stderr,
+		})
+		result.duration = time.Since(result.start)
+
+		stdout.Flush()
+		if result.err != nil {
+			fmt.Fprintf(stderr, "❌ %v\n", result.err)
+		}
+		stderr.Flush()
+
+		return result
+	})
+
+	printExecSummary(results)
+
+	return execExitCode(results)
+}
+
+// 收集各服务器的输出，json 在全部完成后输出数组，jsonl 每完成一台输出一行
+func execBatchJSON(targets []execTarget, ef execFlags) int {
+	var mu sync.Mutex
+	encoder := json.NewEncoder(os.Stdout)
+
+	results := execParallel(targets, ef.parallel, func(target execTarget) execResult {
+		var stdout, stderr bytes.Buffer
+		result := execResult{target: target, start: time.Now()}
+		result.code, result.err = target.server.Exec(ExecOptions{
+			Command: ef.command,
+			Sudo:    ef.sudo,
+			Stdout:  &stdout,
+			Stderr:  &stderr,
+		})
+		result.duration = time.Since(result.start)
+		result.stdout, result.stderr = stdout.String(), stderr.String()
+
+		if ef.output == ExecOutputJSONL {
+			mu.Lock()
+			_ = encoder.Encode(result.report(ef.command))
+			mu.Unlock()
+		}
+		return result
+	})
+
+	if ef.output == ExecOutputJSON {
+		reports := make([]ExecReport, 0, len(results))
+		for _, result := range results {
+			reports = append(reports, result.report(ef.command))
+		}
+		encoder.SetIndent("", "  ")
+		_ = encoder.Encode(reports)
+	}
+
+	return execExitCode(results)
+}
+
+// 只有一台服务器时返回其退出码；多台时全部成功返回 0，否则返回 1
+func execExitCode(results []execResult) int {
+	if len(results) == 1 {
+		return results[0].code
+	}
+
+	for _, result := range results {
+		if result.err != nil || result.code != 0 {
+			return 1
+		}
+	}
+	return 0
+}
+
+// 打印各服务器的退出码与耗时
+func printExecSummary(results []execResult) {
+	rows := [][]string{{"服务器", "退出码", "耗时", "结果"}}
+	succeeded := 0
+	for _, result := range results {
+		status := "✅ 成功"
+		switch {
+		case result.err != nil:
+			status = "❌ " + result.err.Error()
+		case result.code != 0:
+			status = "⚠️  失败"
+		default:
+			succeeded++
+		}
+		rows = append(rows, []string{
+			result.target.label,
+			strconv.Itoa(result.code),
+			result.duration.Round(time.Millisecond).String(),
+			status,
+		})
+	}
+
+	widths := make([]int, len(rows[0]))
+	for _, row := range rows {
+		for i, col := range row {
+			if l := utils.ZhLen(col); l > widths[i] {
+				widths[i] = l
+			}
+		}
+	}
+
+	fmt.Println()
+	for _, row := range rows {
+		var line strings.Builder
+		for i, col := range row {
+			line.WriteString(col)
+			if i < len(row)-1 {
+				line.WriteString(strings.Repeat(" ", widths[i]-utils.ZhLen(col)+2))
+			}
+		}
+		fmt.Println(line.String())
+	}
+	fmt.Printf("\n共 %d 台，成功 %d 台，失败 %d 台\n", len(results), succeeded, len(results)-succeeded)
+}
+
+// 为每一行输出加上前缀，多个服务器共享同一把锁保证行不交错
+type prefixWriter struct {
+	w      io.Writer
+	mu     *sync.Mutex
+	prefix string
+	buf    []byte
+}
+
+func newPrefixWriter(w io.Writer, mu *sync.Mutex, prefix string) *prefixWriter {
+	return &prefixWriter{w: w, mu: mu, prefix: prefix}
+}
+
+func (pw *prefixWriter) Write(p []byte) (int, error) {
+	pw.buf = append(pw.buf, p...)
+
+	var out bytes.Buffer
+	for {
+		i := bytes.IndexByte(pw.buf, '\n')
+		if i < 0 {
+			break
+		}
+		out.WriteString(pw.prefix)
+		out.Write(pw.buf[:i+1])
+		pw.buf = pw.buf[i+1:]
+	}
+
+	if out.Len() > 0 {
+		pw.mu.Lock()
+		defer pw.mu.Unlock()
+		if _, err := pw.w.Write(out.Bytes()); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// 输出缓冲中不以换行结尾的剩余内容
+func (pw *prefixWriter) Flush() {
+	if len(pw.buf) == 0 {
+		return
+	}
+	_, _ = pw.Write([]byte{'\n'})
+}
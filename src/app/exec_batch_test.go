@@ -0,0 +1,203 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestPrefixWriter(t *testing.T) {
+	var buf bytes.Buffer
+	var mu sync.Mutex
+	pw := newPrefixWriter(&buf, &mu, "[web01] ")
+
+	_, _ = pw.Write([]byte("line one\nline "))
+	_, _ = pw.Write([]byte("two\npartial"))
+	pw.Flush()
+
+	want := "[web01] line one\n[web01] line two\n[web01] partial\n"
+	if buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+}
+
+func TestConfig_execTargets(t *testing.T) {
+	cfg := &Config{
+		Servers: []*Server{{Name: "a", Ip: "10.0.0.1", User: "root", Alias: "web01"}},
+		Groups: []*Group{{
+			GroupName: "测试环境",
+			Prefix:    "t",
+			Servers:   []Server{{Name: "b", Ip: "10.0.0.2", User: "root"}, {Name: "c", Ip: "10.0.0.3", User: "root", Alias: "tdb"}},
+		}},
+	}
+	cfg.createServerIndex()
+
+	targets, err := cfg.execTargets("t", "web01,t1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var labels []string
+	for _, target := range targets {
+		labels = append(labels, target.label)
+	}
+	if len(labels) != 3 || labels[0] != "t1" || labels[1] != "tdb" || labels[2] != "web01" {
+		t.Errorf("got %v", labels)
+	}
+
+	if _, err := cfg.execTargets("x", ""); err == nil {
+		t.Error("expected error for unknown group")
+	}
+}
+
+func TestExecParallel(t *testing.T) {
+	var targets []execTarget
+	for _, label := range []string{"a", "b", "c", "d", "e"} {
+		targets = append(targets, execTarget{label: label, server: startTestSSHServer(t)})
+	}
+
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+	results := execParallel(targets, 2, func(target execTarget) execResult {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			running--
+			mu.Unlock()
+		}()
+
+		var stdout bytes.Buffer
+		code, err := target.server.Exec(ExecOptions{Command: "sleep 0.1; echo " + target.label + "; exit 2", Stdout: &stdout})
+		if stdout.String() != target.label+"\n" {
+			t.Errorf("%s: stdout %q", target.label, stdout.String())
+		}
+		return execResult{target: target, code: code, err: err}
+	})
+
+	if maxRunning != 2 {
+		t.Errorf("expected at most 2 concurrent executions and some overlap, got %d", maxRunning)
+	}
+	for i, result := range results {
+		if result.target.label != targets[i].label || result.code != 2 || result.err != nil {
+			t.Errorf("unexpected result %+v", result)
+		}
+	}
+}
+
+func TestExecResult_report(t *testing.T) {
+	server := startTestSSHServer(t)
+
+	// 端口1上没有服务监听，连接会被拒绝
+	closed := &Server{
+		Name:     "down",
+		Ip:       "127.0.0.1",
+		Port:     1,
+		User:     "root",
+		Password: "x",
+		Method:   "password",
+		Options:  map[string]interface{}{"StrictHostKeyChecking": HostKeyCheckOff},
+	}
+
+	for _, tc := range []struct {
+		server    *Server
+		exitCode  int
+		errorType string
+	}{
+		{server, 0, ""},
+		{closed, ExitCodeConnectFailed, "connection_refused"},
+	} {
+		var stdout bytes.Buffer
+		result := execResult{target: execTarget{label: tc.server.Name, server: tc.server}}
+		result.code, result.err = tc.server.Exec(ExecOptions{Command: "echo ok", Stdout: &stdout})
+		result.stdout = stdout.String()
+
+		report := result.report("echo ok")
+		if report.ExitCode != tc.exitCode || report.ErrorType != tc.errorType {
+			t.Errorf("%s: got %+v", tc.server.Name, report)
+		}
+		if tc.errorType == "" && report.Stdout != "ok\n" {
+			t.Errorf("%s: stdout %q", tc.server.Name, report.Stdout)
+		}
+	}
+}
+
+// 首次连接写入 known_hosts 等提示必须输出到标准错误，标准输出只包含 JSON
+func TestExecBatchJSON_pureStdout(t *testing.T) {
+	dir := t.TempDir()
+	var targets []execTarget
+	for _, label := range []string{"a", "b"} {
+		server := startTestSSHServer(t)
+		server.Options = map[string]interface{}{
+			"StrictHostKeyChecking": HostKeyCheckAcceptNew,
+			"KnownHostsFile":        filepath.Join(dir, "known_hosts_"+label),
+			"GlobalKnownHostsFile":  filepath.Join(dir, "missing"),
+		}
+		targets = append(targets, execTarget{label: label, server: server})
+	}
+
+	for _, output := range []string{ExecOutputJSONL, ExecOutputJSON} {
+		stdout, stderr := captureOutput(t, func() {
+			execBatchJSON(targets, execFlags{command: "echo ok", parallel: 2, output: output})
+		})
+
+		var reports []ExecReport
+		if output == ExecOutputJSON {
+			if err := json.Unmarshal([]byte(stdout), &reports); err != nil {
+				t.Fatalf("%s: stdout is not JSON: %v\n%s", output, err, stdout)
+			}
+		} else {
+			for _, line := range strings.Split(strings.TrimSpace(stdout), "\n") {
+				var report ExecReport
+				if err := json.Unmarshal([]byte(line), &report); err != nil {
+					t.Fatalf("%s: stdout line is not JSON: %v\n%s", output, err, stdout)
+				}
+				reports = append(reports, report)
+			}
+		}
+		if len(reports) != len(targets) {
+			t.Errorf("%s: got %d reports", output, len(reports))
+		}
+		for _, report := range reports {
+			if report.ExitCode != 0 || report.Stdout != "ok\n" {
+				t.Errorf("%s: unexpected report %+v", output, report)
+			}
+		}
+
+		// 只有第一轮会写入 known_hosts
+		if output == ExecOutputJSONL && !strings.Contains(stderr, "known_hosts") {
+			t.Errorf("expected host key notice on stderr, got %q", stderr)
+		}
+	}
+}
+
+// 将 os.Stdout 与 os.Stderr 临时重定向到文件，返回 fn 执行期间的输出
+func captureOutput(t *testing.T, fn func()) (string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	files := make([]*os.File, 2)
+	for i, name := range []string{"stdout", "stderr"} {
+		f, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		files[i] = f
+	}
+
+	oldStdout, oldStderr := os.Stdout, os.Stderr
+	os.Stdout, os.Stderr = files[0], files[1]
+	defer func() { os.Stdout, os.Stderr = oldStdout, oldStderr }()
+	fn()
+
+	stdout, _ := os.ReadFile(files[0].Name())
+	stderr, _ := os.ReadFile(files[1].Name())
+	return string(stdout), string(stderr)
+}
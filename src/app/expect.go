@@ -0,0 +1,270 @@
+package app
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// 登录后自动应答的默认等待时间（秒）
+const defaultExpectTimeout = 10
+
+// 启动命令等待 Shell 提示符的时间（秒），命令持续有输出时重新计时
+const defaultStartupTimeout = 30
+
+// 默认的 Shell 提示符：输出以 $、#、> 或 % 结尾
+const defaultStartupPrompt = `[$#>%]\s*$`
+
+// 保留的最大输出长度，避免长时间未匹配时缓冲无限增长
+const expectBufferSize = 64 * 1024
+
+// 登录后的自动应答规则：等待输出匹配 expect（正则表达式）后发送 send 并回车
+// 例如在跳板机菜单中选择目标主机、输入 su 密码
+type ExpectRule struct {
+	Expect  string `json:"expect"`
+	Send    string `json:"send"`
+	Timeout int    `json:"timeout,omitempty"`
+}
+
+// validate 验证自动应答规则
+func (r *ExpectRule) validate() error {
+	if r.Expect == "" {
+		return fmt.Errorf("expect 不能为空")
+	}
+	if _, err := regexp.Compile(r.Expect); err != nil {
+		return fmt.Errorf("expect 不是有效的正则表达式: %w", err)
+	}
+	if r.Timeout < 0 {
+		return fmt.Errorf("timeout 不能为负数")
+	}
+	return nil
+}
+
+func (r ExpectRule) timeout() time.Duration {
+	if r.Timeout > 0 {
+		return time.Duration(r.Timeout) * time.Second
+	}
+	return defaultExpectTimeout * time.Second
+}
+
+// 自动应答的一步：等待输出匹配 pattern 后发送 send
+// gated 的步骤执行期间不转发用户输入（自动应答规则）；
+// 非 gated 的步骤（启动命令）执行时用户可以正常输入，例如回答 sudo -i 的密码提示
+type expectStep struct {
+	pattern *regexp.Regexp
+	send    string
+	timeout time.Duration
+	gated   bool
+}
+
+func newExpectSteps(rules []ExpectRule) ([]expectStep, error) {
+	steps := make([]expectStep, len(rules))
+	for i, rule := range rules {
+		re, err := regexp.Compile(rule.Expect)
+		if err != nil {
+			return nil, fmt.Errorf("自动应答规则[%d]无效: %w", i, err)
+		}
+		steps[i] = expectStep{pattern: re, send: rule.Send + "\n", timeout: rule.timeout(), gated: true}
+	}
+	return steps, nil
+}
+
+// 启动命令：每条命令都在出现 Shell 提示符后才发送
+func newStartupSteps(prompt string, commands []string) ([]expectStep, error) {
+	if prompt == "" {
+		prompt = defaultStartupPrompt
+	}
+	re, err := regexp.Compile(prompt)
+	if err != nil {
+		return nil, fmt.Errorf("startup_prompt 不是有效的正则表达式: %w", err)
+	}
+
+	steps := make([]expectStep, len(commands))
+	for i, command := range commands {
+		steps[i] = expectStep{pattern: re, send: command + "\n", timeout: defaultStartupTimeout * time.Second}
+	}
+	return steps, nil
+}
+
+// 按顺序执行自动应答步骤，作为会话的标准输入：
+// 遇到第一个非 gated 步骤或全部完成、超时后才转交 rest（用户输入）
+type expecter struct {
+	steps []expectStep
+
+	mu       sync.Mutex
+	step     int
+	buf      []byte
+	timer    *time.Timer
+	done     bool
+	released bool
+	sends    chan string
+
+	pr *io.PipeReader
+}
+
+// 通过 sends 通知写入协程开始转发用户输入，保证此前的应答先写入
+const expectRelease = ""
+
+func newExpecter(steps []expectStep, rest io.Reader) *expecter {
+	pr, pw := io.Pipe()
+	e := &expecter{
+		steps: steps,
+		sends: make(chan string, len(steps)+1),
+		pr:    pr,
+	}
+
+	var wmu sync.Mutex
+	write := func(p []byte) error {
+		wmu.Lock()
+		defer wmu.Unlock()
+		_, err := pw.Write(p)
+		return err
+	}
+
+	release := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		defer func() {
+			select {
+			case <-release:
+			default:
+				close(release)
+			}
+		}()
+		for send := range e.sends {
+			if send == expectRelease {
+				close(release)
+				continue
+			}
+			if err := write([]byte(send)); err != nil {
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		<-release
+		_, _ = io.Copy(writerFunc(func(p []byte) (int, error) {
+			if err := write(p); err != nil {
+				return 0, err
+			}
+			return len(p), nil
+		}), rest)
+	}()
+	go func() {
+		wg.Wait()
+		pw.Close()
+	}()
+
+	e.mu.Lock()
+	e.begin()
+	e.mu.Unlock()
+
+	return e
+}
+
+func (e *expecter) Read(p []byte) (int, error) {
+	return e.pr.Read(p)
+}
+
+// 包装服务器输出，写入 w 的同时用于匹配规则
+func (e *expecter) writer(w io.Writer) io.Writer {
+	return expectWriter{w: w, e: e}
+}
+
+// 检查服务器输出，依次匹配规则并发送应答
+func (e *expecter) observe(p []byte) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.done {
+		return
+	}
+
+	e.buf = append(e.buf, p...)
+	matched := false
+	for !e.done {
+		loc := e.steps[e.step].pattern.FindIndex(e.buf)
+		if loc == nil {
+			break
+		}
+
+		matched = true
+		e.buf = e.buf[loc[1]:]
+		e.timer.Stop()
+		e.sends <- e.steps[e.step].send
+
+		e.step++
+		e.begin()
+	}
+
+	// 启动命令仍在输出时重新计时，避免耗时较长的命令被误判为超时
+	if !matched && !e.done && !e.steps[e.step].gated {
+		e.timer.Reset(e.steps[e.step].timeout)
+	}
+
+	if len(e.buf) > expectBufferSize {
+		e.buf = e.buf[len(e.buf)-expectBufferSize:]
+	}
+}
+
+// 开始等待当前步骤，全部完成时结束
+func (e *expecter) begin() {
+	if e.step == len(e.steps) {
+		e.finish()
+		return
+	}
+	if !e.steps[e.step].gated && !e.released {
+		e.released = true
+		e.sends <- expectRelease
+	}
+	e.armTimer()
+}
+
+// 为当前步骤设置超时，超时后放弃剩余步骤，交由用户操作
+func (e *expecter) armTimer() {
+	step := e.step
+	current := e.steps[step]
+	e.timer = time.AfterFunc(current.timeout, func() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+
+		if e.done || e.step != step {
+			return
+		}
+		if current.gated {
+			fmt.Fprintf(os.Stderr, "\r\n\033[33m⚠️  等待 %q 超时，自动应答已停止，请手动操作\033[0m\r\n", current.pattern.String())
+		} else {
+			fmt.Fprintf(os.Stderr, "\r\n\033[33m⚠️  等待Shell提示符超时，剩余启动命令已取消\033[0m\r\n")
+		}
+		e.finish()
+	})
+}
+
+func (e *expecter) finish() {
+	e.done = true
+	e.buf = nil
+	close(e.sends)
+}
+
+type expectWriter struct {
+	w io.Writer
+	e *expecter
+}
+
+func (ew expectWriter) Write(p []byte) (int, error) {
+	n, err := ew.w.Write(p)
+	ew.e.observe(p[:n])
+	return n, err
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
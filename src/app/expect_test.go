@@ -0,0 +1,168 @@
+package app
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExpecter(t *testing.T) {
+	rules := []ExpectRule{
+		{Expect: `请选择目标主机.*:\s*$`, Send: "2"},
+		{Expect: `(?i)password:`, Send: "s3cret"},
+	}
+	steps, err := newExpectSteps(rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := newExpecter(steps, strings.NewReader("whoami\n"))
+
+	var screen bytes.Buffer
+	w := e.writer(&screen)
+	go func() {
+		_, _ = w.Write([]byte("欢迎使用跳板机\r\n1) web01\r\n2) db01\r\n请选择目标主机"))
+		_, _ = w.Write([]byte(": "))
+		_, _ = w.Write([]byte("Connecting...\r\nPass"))
+		_, _ = w.Write([]byte("word: "))
+	}()
+
+	got, err := io.ReadAll(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "2\ns3cret\nwhoami\n" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestExpecter_timeout(t *testing.T) {
+	steps, err := newExpectSteps([]ExpectRule{{Expect: "never", Send: "x", Timeout: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := newExpecter(steps, strings.NewReader("ls\n"))
+
+	got, err := io.ReadAll(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "ls\n" {
+		t.Errorf("got %q", got)
+	}
+}
+
+// 启动命令在提示符出现后才逐条发送，执行期间用户输入照常转发
+func TestExpecter_startup(t *testing.T) {
+	server := &Server{Startup: []string{"cd /srv/app", "sudo -i"}}
+	steps, err := server.interactiveSteps()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userInput, typing := io.Pipe()
+	e := newExpecter(steps, userInput)
+	w := e.writer(io.Discard)
+
+	sent := make(chan string, 1)
+	go func() {
+		buf := make([]byte, 64)
+		for {
+			n, err := e.Read(buf)
+			if err != nil {
+				close(sent)
+				return
+			}
+			sent <- string(buf[:n])
+		}
+	}()
+
+	expectSent := func(want string) {
+		t.Helper()
+		select {
+		case got := <-sent:
+			if got != want {
+				t.Fatalf("got %q, want %q", got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for %q", want)
+		}
+	}
+	expectNothing := func() {
+		t.Helper()
+		select {
+		case got := <-sent:
+			t.Fatalf("unexpected input %q", got)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	_, _ = w.Write([]byte("Last login: Mon\r\n"))
+	expectNothing()
+	_, _ = w.Write([]byte("deploy@web01:~$ "))
+	expectSent("cd /srv/app\n")
+
+	_, _ = w.Write([]byte("cd /srv/app\r\n"))
+	expectNothing()
+	_, _ = w.Write([]byte("deploy@web01:/srv/app$ "))
+	expectSent("sudo -i\n")
+
+	// sudo -i 的密码由用户输入
+	_, _ = w.Write([]byte("[sudo] password for deploy: "))
+	_, _ = typing.Write([]byte("pw\n"))
+	expectSent("pw\n")
+
+	// 未开启 keep_shell，最后一条命令完成后退出
+	_, _ = w.Write([]byte("\r\nroot@web01:~# "))
+	expectSent("exit\n")
+	typing.Close()
+}
+
+func TestServer_interactiveSteps(t *testing.T) {
+	server := &Server{
+		Expect:  []ExpectRule{{Expect: "Select:", Send: "1"}},
+		Startup: []string{"cd /srv/app"},
+	}
+
+	steps, err := server.interactiveSteps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sends []string
+	for _, step := range steps {
+		sends = append(sends, step.send)
+	}
+	if got := strings.Join(sends, ""); got != "1\ncd /srv/app\nexit\n" || !steps[0].gated || steps[1].gated {
+		t.Errorf("unexpected steps %q", got)
+	}
+
+	server.KeepShell = true
+	if steps, _ := server.interactiveSteps(); len(steps) != 2 {
+		t.Errorf("keep_shell should not send exit, got %d steps", len(steps))
+	}
+
+	server.StartupPrompt = "("
+	if _, err := server.interactiveSteps(); err == nil {
+		t.Error("expected invalid startup_prompt error")
+	}
+}
+
+func TestServer_getRemoteCommand(t *testing.T) {
+	server := &Server{RemoteCommand: " tmux attach "}
+	if got := server.getRemoteCommand(); got != "tmux attach" {
+		t.Errorf("got %q", got)
+	}
+	server.KeepShell = true
+	if got := server.getRemoteCommand(); got != `tmux attach; exec "$SHELL" -l` {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestExpectRule_validate(t *testing.T) {
+	for _, rule := range []ExpectRule{{Expect: ""}, {Expect: "("}, {Expect: "ok", Timeout: -1}} {
+		if err := rule.validate(); err == nil {
+			t.Errorf("%+v: expected error", rule)
+		}
+	}
+}
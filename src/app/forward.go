@@ -0,0 +1,511 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+type ForwardType string
+
+const (
+	ForwardTypeLocal   ForwardType = "local"
+	ForwardTypeRemote  ForwardType = "remote"
+	ForwardTypeDynamic ForwardType = "dynamic"
+)
+
+// 端口转发配置
+// local:  在本地 bind_address:local_port 监听，经服务器连接 remote_host:remote_port
+// remote: 在服务器 bind_address:remote_port 监听，连接本地 local_host:local_port
+// dynamic: 在本地 bind_address:local_port 提供SOCKS5代理，经服务器连接请求的目标
+// local_socket / remote_socket 设置后，对应一端改用Unix套接字（OpenSSH streamlocal 扩展）
+type Forward struct {
+	Type         ForwardType `json:"type"`
+	BindAddress  string      `json:"bind_address,omitempty"`
+	LocalHost    string      `json:"local_host,omitempty"`
+	LocalPort    int         `json:"local_port"`
+	LocalSocket  string      `json:"local_socket,omitempty"`
+	RemoteHost   string      `json:"remote_host,omitempty"`
+	RemotePort   int         `json:"remote_port"`
+	RemoteSocket string      `json:"remote_socket,omitempty"`
+}
+
+// 监听端使用的套接字路径，为空表示TCP
+func (f Forward) listenSocket() string {
+	if f.Type == ForwardTypeRemote {
+		return f.RemoteSocket
+	}
+	return f.LocalSocket
+}
+
+// 目标端使用的套接字路径，为空表示TCP
+func (f Forward) targetSocket() string {
+	if f.Type == ForwardTypeRemote {
+		return f.LocalSocket
+	}
+	return f.RemoteSocket
+}
+
+func (f Forward) listenNetwork() string {
+	if f.listenSocket() != "" {
+		return "unix"
+	}
+	return "tcp"
+}
+
+func (f Forward) targetNetwork() string {
+	if f.targetSocket() != "" {
+		return "unix"
+	}
+	return "tcp"
+}
+
+// 监听地址，未指定时只监听回环地址
+func (f Forward) listenAddress() string {
+	if socket := f.listenSocket(); socket != "" {
+		return socket
+	}
+
+	bind := f.BindAddress
+	switch bind {
+	case "", "localhost":
+		bind = "127.0.0.1"
+	case "*":
+		bind = "0.0.0.0"
+	}
+
+	if f.Type == ForwardTypeRemote {
+		return net.JoinHostPort(bind, strconv.Itoa(f.RemotePort))
+	}
+	return net.JoinHostPort(bind, strconv.Itoa(f.LocalPort))
+}
+
+// 转发目标地址
+func (f Forward) targetAddress() string {
+	if socket := f.targetSocket(); socket != "" {
+		return socket
+	}
+
+	if f.Type == ForwardTypeRemote {
+		host := f.LocalHost
+		if host == "" {
+			host = "127.0.0.1"
+		}
+		return net.JoinHostPort(host, strconv.Itoa(f.LocalPort))
+	}
+	return net.JoinHostPort(f.RemoteHost, strconv.Itoa(f.RemotePort))
+}
+
+func (f Forward) String() string {
+	switch f.Type {
+	case ForwardTypeRemote:
+		return "远程转发 (服务器)" + f.listenAddress() + " -> (本地)" + f.targetAddress()
+	case ForwardTypeDynamic:
+		return "动态转发 (SOCKS5) " + f.listenAddress()
+	}
+	return "本地转发 (本地)" + f.listenAddress() + " -> (服务器)" + f.targetAddress()
+}
+
+// validate 验证转发配置
+func (f *Forward) validate() error {
+	switch f.Type {
+	case ForwardTypeLocal, ForwardTypeRemote:
+	case ForwardTypeDynamic:
+		if f.LocalSocket != "" || f.RemoteSocket != "" {
+			return fmt.Errorf("动态转发不支持Unix套接字")
+		}
+	default:
+		return fmt.Errorf("不支持的转发类型: %s", f.Type)
+	}
+
+	if f.LocalSocket == "" && (f.LocalPort <= 0 || f.LocalPort > 65535) {
+		return fmt.Errorf("本地端口必须在1-65535之间")
+	}
+	if f.Type == ForwardTypeDynamic || f.RemoteSocket != "" {
+		return nil
+	}
+	if f.Type == ForwardTypeLocal && f.RemoteHost == "" {
+		return fmt.Errorf("转发目标主机不能为空")
+	}
+	if f.RemotePort <= 0 || f.RemotePort > 65535 {
+		return fmt.Errorf("远程端口必须在1-65535之间")
+	}
+	return nil
+}
+
+// 解析 -L 参数，格式与 OpenSSH 相同：[bind_address:]port:host:hostport
+// 任意一端可以是Unix套接字路径，例如 /tmp/docker.sock:/var/run/docker.sock
+func parseLocalForward(spec string) (Forward, error) {
+	listen, target, err := parseForwardSpec(spec)
+	if err != nil {
+		return Forward{}, err
+	}
+
+	f := Forward{
+		Type:         ForwardTypeLocal,
+		BindAddress:  listen.host,
+		LocalPort:    listen.port,
+		LocalSocket:  listen.socket,
+		RemoteHost:   target.host,
+		RemotePort:   target.port,
+		RemoteSocket: target.socket,
+	}
+	if err := f.validate(); err != nil {
+		return Forward{}, err
+	}
+	return f, nil
+}
+
+// 解析 -R 参数，格式与 OpenSSH 相同：[bind_address:]port:host:hostport
+// port 为服务器上的监听端口，host:hostport 为本地目标地址；任意一端可以是Unix套接字路径
+func parseRemoteForward(spec string) (Forward, error) {
+	listen, target, err := parseForwardSpec(spec)
+	if err != nil {
+		return Forward{}, err
+	}
+
+	f := Forward{
+		Type:         ForwardTypeRemote,
+		BindAddress:  listen.host,
+		RemotePort:   listen.port,
+		RemoteSocket: listen.socket,
+		LocalHost:    target.host,
+		LocalPort:    target.port,
+		LocalSocket:  target.socket,
+	}
+	if err := f.validate(); err != nil {
+		return Forward{}, err
+	}
+	return f, nil
+}
+
+// 解析 -D 参数，格式与 OpenSSH 相同：[bind_address:]port
+func parseDynamicForward(spec string) (Forward, error) {
+	parts, err := splitForwardSpec(spec)
+	if err != nil {
+		return Forward{}, err
+	}
+
+	f := Forward{Type: ForwardTypeDynamic}
+	switch len(parts) {
+	case 1:
+	case 2:
+		f.BindAddress = parts[0]
+		parts = parts[1:]
+	default:
+		return Forward{}, fmt.Errorf("转发参数格式错误: %s（应为 [bind_address:]port）", spec)
+	}
+
+	if f.LocalPort, err = strconv.Atoi(parts[0]); err != nil {
+		return Forward{}, fmt.Errorf("监听端口无效: %s", parts[0])
+	}
+	if err := f.validate(); err != nil {
+		return Forward{}, err
+	}
+	return f, nil
+}
+
+// 转发的一端：host:port 或 Unix套接字路径
+type forwardEndpoint struct {
+	host   string
+	port   int
+	socket string
+}
+
+// 含有斜杠的参数视为Unix套接字路径
+func isSocketPath(s string) bool {
+	return strings.Contains(s, "/")
+}
+
+// 解析 [bind_address:]port:host:hostport，其中监听端和目标端均可替换为Unix套接字路径
+func parseForwardSpec(spec string) (listen, target forwardEndpoint, err error) {
+	parts, err := splitForwardSpec(spec)
+	if err != nil {
+		return listen, target, err
+	}
+	formatErr := fmt.Errorf("转发参数格式错误: %s（应为 [bind_address:]port:host:hostport）", spec)
+
+	// 目标端：套接字路径占一段，host:hostport 占两段
+	targetParts := 2
+	if isSocketPath(parts[len(parts)-1]) {
+		targetParts = 1
+	}
+	if len(parts) <= targetParts {
+		return listen, target, formatErr
+	}
+	listenParts, targetRest := parts[:len(parts)-targetParts], parts[len(parts)-targetParts:]
+
+	switch {
+	case len(listenParts) == 1 && isSocketPath(listenParts[0]):
+		listen.socket = listenParts[0]
+	case len(listenParts) == 1 || len(listenParts) == 2:
+		if len(listenParts) == 2 {
+			listen.host = listenParts[0]
+		}
+		port := listenParts[len(listenParts)-1]
+		if listen.port, err = strconv.Atoi(port); err != nil {
+			return listen, target, fmt.Errorf("监听端口无效: %s", port)
+		}
+	default:
+		return listen, target, formatErr
+	}
+
+	if targetParts == 1 {
+		target.socket = targetRest[0]
+		return listen, target, nil
+	}
+
+	target.host = targetRest[0]
+	if target.host == "" {
+		return listen, target, fmt.Errorf("转发目标主机不能为空")
+	}
+	if target.port, err = strconv.Atoi(targetRest[1]); err != nil {
+		return listen, target, fmt.Errorf("目标端口无效: %s", targetRest[1])
+	}
+
+	return listen, target, nil
+}
+
+// 按冒号拆分转发参数，方括号内的 IPv6 地址作为整体
+func splitForwardSpec(spec string) ([]string, error) {
+	var parts []string
+	var current strings.Builder
+	inBracket := false
+
+	for _, r := range spec {
+		switch {
+		case r == '[' && !inBracket:
+			inBracket = true
+		case r == ']' && inBracket:
+			inBracket = false
+		case r == ':' && !inBracket:
+			parts = append(parts, current.String())
+			current.Reset()
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if inBracket {
+		return nil, fmt.Errorf("转发参数格式错误: %s（方括号未闭合）", spec)
+	}
+
+	return append(parts, current.String()), nil
+}
+
+// 命令行 -L/-R 参数
+type forwardFlag struct {
+	parse func(spec string) (Forward, error)
+}
+
+func (f forwardFlag) String() string {
+	return ""
+}
+
+func (f forwardFlag) Set(spec string) error {
+	forward, err := f.parse(spec)
+	if err != nil {
+		return err
+	}
+	cliForwards = append(cliForwards, forward)
+	return nil
+}
+
+// 端口转发器
+// 本地监听与SSH连接解耦，连接通过当前的SSH客户端转发；
+// 远程监听依附于SSH连接，每次设置新的客户端时重新建立
+type forwarder struct {
+	forwards []Forward
+
+	mu              sync.RWMutex
+	client          *ssh.Client
+	listeners       []net.Listener
+	remoteListeners []net.Listener
+}
+
+func newForwarder(forwards []Forward) *forwarder {
+	return &forwarder{forwards: forwards}
+}
+
+// 设置用于转发的SSH客户端，并在该连接上建立远程监听
+func (fw *forwarder) setClient(client *ssh.Client) error {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	fw.closeRemoteListeners()
+	fw.client = client
+	if client == nil {
+		return nil
+	}
+
+	for _, f := range fw.forwards {
+		if f.Type != ForwardTypeRemote {
+			continue
+		}
+
+		ln, err := client.Listen(f.listenNetwork(), f.listenAddress())
+		if err != nil {
+			fw.closeRemoteListeners()
+			return fmt.Errorf("服务器监听 %s 失败: %w", f.listenAddress(), err)
+		}
+		fw.remoteListeners = append(fw.remoteListeners, ln)
+
+		go fw.serveRemote(ln, f)
+	}
+	return nil
+}
+
+func (fw *forwarder) closeRemoteListeners() {
+	for _, ln := range fw.remoteListeners {
+		ln.Close()
+	}
+	fw.remoteListeners = nil
+}
+
+// 通过当前SSH客户端建立到目标的连接
+func (fw *forwarder) dial(network, addr string) (net.Conn, error) {
+	fw.mu.RLock()
+	client := fw.client
+	fw.mu.RUnlock()
+
+	if client == nil {
+		return nil, errors.New("SSH连接尚未建立")
+	}
+	return client.Dial(network, addr)
+}
+
+// 启动所有本地监听，任意一个失败时关闭已启动的监听
+func (fw *forwarder) start() error {
+	for _, f := range fw.forwards {
+		if f.Type != ForwardTypeLocal && f.Type != ForwardTypeDynamic {
+			continue
+		}
+
+		ln, err := listenLocal(f.listenNetwork(), f.listenAddress())
+		if err != nil {
+			fw.close()
+			return fmt.Errorf("监听 %s 失败: %w", f.listenAddress(), err)
+		}
+
+		fw.mu.Lock()
+		fw.listeners = append(fw.listeners, ln)
+		fw.mu.Unlock()
+
+		if f.Type == ForwardTypeDynamic {
+			go fw.serveDynamic(ln)
+		} else {
+			go fw.serveLocal(ln, f)
+		}
+	}
+	return nil
+}
+
+// 本地监听，Unix套接字文件已存在但无人监听时（上次异常退出遗留）先删除
+func listenLocal(network, addr string) (net.Listener, error) {
+	if network == "unix" {
+		if info, err := os.Stat(addr); err == nil && info.Mode()&os.ModeSocket != 0 {
+			if conn, err := net.Dial(network, addr); err == nil {
+				conn.Close()
+			} else {
+				_ = os.Remove(addr)
+			}
+		}
+	}
+	return net.Listen(network, addr)
+}
+
+func (fw *forwarder) serveLocal(ln net.Listener, f Forward) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			remote, err := fw.dial(f.targetNetwork(), f.targetAddress())
+			if err != nil {
+				forwardLogf("转发到 %s 失败: %v", f.targetAddress(), err)
+				conn.Close()
+				return
+			}
+			pipeConn(conn, remote)
+		}()
+	}
+}
+
+// 本地SOCKS5代理，每个请求的目标经服务器连接
+func (fw *forwarder) serveDynamic(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+
+		go serveSocks5(conn, fw.dial)
+	}
+}
+
+// 接受服务器上的连接并转发到本地目标
+func (fw *forwarder) serveRemote(ln net.Listener, f Forward) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			local, err := net.Dial(f.targetNetwork(), f.targetAddress())
+			if err != nil {
+				forwardLogf("转发到本地 %s 失败: %v", f.targetAddress(), err)
+				conn.Close()
+				return
+			}
+			pipeConn(conn, local)
+		}()
+	}
+}
+
+// 关闭所有监听
+func (fw *forwarder) close() {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	for _, ln := range fw.listeners {
+		ln.Close()
+	}
+	fw.listeners = nil
+	fw.closeRemoteListeners()
+}
+
+// 双向复制数据，一端结束时半关闭另一端，两端都结束后关闭连接
+func pipeConn(a, b io.ReadWriteCloser) {
+	var wg sync.WaitGroup
+	copyHalf := func(dst, src io.ReadWriteCloser) {
+		defer wg.Done()
+		_, _ = io.Copy(dst, src)
+		if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+			_ = cw.CloseWrite()
+		} else {
+			dst.Close()
+		}
+	}
+
+	wg.Add(2)
+	go copyHalf(a, b)
+	go copyHalf(b, a)
+	wg.Wait()
+
+	a.Close()
+	b.Close()
+}
+
+// 转发过程中的提示信息，终端可能处于原始模式，需显式回车换行
+func forwardLogf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "\r\033[31m"+format+"\033[0m\r\n", args...)
+}
@@ -0,0 +1,137 @@
+package app
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// SOCKS5 协议常量（RFC 1928）
+const (
+	socks5Version = 0x05
+
+	socks5AuthNone         = 0x00
+	socks5AuthNoAcceptable = 0xff
+
+	socks5CmdConnect = 0x01
+
+	socks5AtypIPv4   = 0x01
+	socks5AtypDomain = 0x03
+	socks5AtypIPv6   = 0x04
+
+	socks5ReplySucceeded           = 0x00
+	socks5ReplyHostUnreachable     = 0x04
+	socks5ReplyCommandNotSupported = 0x07
+	socks5ReplyAddrNotSupported    = 0x08
+)
+
+// 处理一个SOCKS5客户端连接，仅支持无认证的 CONNECT 请求，目标连接由 dial 建立
+func serveSocks5(conn net.Conn, dial func(network, addr string) (net.Conn, error)) {
+	_ = conn.SetDeadline(time.Now().Add(30 * time.Second))
+
+	target, err := socks5Handshake(conn)
+	if err != nil {
+		conn.Close()
+		return
+	}
+
+	remote, err := dial("tcp", target)
+	if err != nil {
+		forwardLogf("SOCKS5 连接 %s 失败: %v", target, err)
+		_ = socks5Reply(conn, socks5ReplyHostUnreachable)
+		conn.Close()
+		return
+	}
+
+	if err := socks5Reply(conn, socks5ReplySucceeded); err != nil {
+		conn.Close()
+		remote.Close()
+		return
+	}
+
+	_ = conn.SetDeadline(time.Time{})
+	pipeConn(conn, remote)
+}
+
+// 完成方法协商并读取请求，返回目标地址 host:port
+func socks5Handshake(conn net.Conn) (string, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return "", err
+	}
+	if header[0] != socks5Version {
+		return "", errors.New("不是SOCKS5请求")
+	}
+
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return "", err
+	}
+
+	method := byte(socks5AuthNoAcceptable)
+	for _, m := range methods {
+		if m == socks5AuthNone {
+			method = socks5AuthNone
+			break
+		}
+	}
+	if _, err := conn.Write([]byte{socks5Version, method}); err != nil {
+		return "", err
+	}
+	if method == socks5AuthNoAcceptable {
+		return "", errors.New("客户端不支持无认证方式")
+	}
+
+	// VER CMD RSV ATYP
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(conn, request); err != nil {
+		return "", err
+	}
+	if request[1] != socks5CmdConnect {
+		_ = socks5Reply(conn, socks5ReplyCommandNotSupported)
+		return "", errors.New("仅支持 CONNECT 命令")
+	}
+
+	var host string
+	switch request[3] {
+	case socks5AtypIPv4, socks5AtypIPv6:
+		size := net.IPv4len
+		if request[3] == socks5AtypIPv6 {
+			size = net.IPv6len
+		}
+		ip := make([]byte, size)
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return "", err
+		}
+		host = net.IP(ip).String()
+	case socks5AtypDomain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return "", err
+		}
+		domain := make([]byte, length[0])
+		if _, err := io.ReadFull(conn, domain); err != nil {
+			return "", err
+		}
+		host = string(domain)
+	default:
+		_ = socks5Reply(conn, socks5ReplyAddrNotSupported)
+		return "", errors.New("不支持的地址类型")
+	}
+
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return "", err
+	}
+
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+// 回复请求结果，绑定地址固定为 0.0.0.0:0
+func socks5Reply(conn net.Conn, reply byte) error {
+	_, err := conn.Write([]byte{socks5Version, reply, 0x00, socks5AtypIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
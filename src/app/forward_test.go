@@ -0,0 +1,383 @@
+package app
+
+import (
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/net/proxy"
+)
+
+func TestParseLocalForward(t *testing.T) {
+	cases := []struct {
+		spec   string
+		listen string
+		target string
+	}{
+		{"8080:localhost:80", "127.0.0.1:8080", "localhost:80"},
+		{"0.0.0.0:5432:db.internal:5432", "0.0.0.0:5432", "db.internal:5432"},
+		{"[::1]:8443:[fd00::10]:443", "[::1]:8443", "[fd00::10]:443"},
+	}
+
+	for _, c := range cases {
+		f, err := parseLocalForward(c.spec)
+		if err != nil {
+			t.Fatalf("%s: %v", c.spec, err)
+		}
+		if f.listenAddress() != c.listen || f.targetAddress() != c.target {
+			t.Errorf("%s: got %s -> %s", c.spec, f.listenAddress(), f.targetAddress())
+		}
+	}
+
+	for _, spec := range []string{"8080", "8080:host", "x:host:80", "8080:host:99999", "[::1:8080:host:80"} {
+		if _, err := parseLocalForward(spec); err == nil {
+			t.Errorf("%s: expected error", spec)
+		}
+	}
+}
+
+func TestParseRemoteForward(t *testing.T) {
+	f, err := parseRemoteForward("*:8080:localhost:3000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.listenAddress() != "0.0.0.0:8080" || f.targetAddress() != "localhost:3000" {
+		t.Errorf("got %s -> %s", f.listenAddress(), f.targetAddress())
+	}
+
+	f, err = parseRemoteForward("9000:10.0.0.2:9000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.listenAddress() != "127.0.0.1:9000" || f.targetAddress() != "10.0.0.2:9000" {
+		t.Errorf("got %s -> %s", f.listenAddress(), f.targetAddress())
+	}
+}
+
+func TestParseSocketForward(t *testing.T) {
+	cases := []struct {
+		spec    string
+		remote  bool
+		network string
+		listen  string
+		target  string
+	}{
+		{"/tmp/docker.sock:/var/run/docker.sock", false, "unix", "/tmp/docker.sock", "/var/run/docker.sock"},
+		{"2375:/var/run/docker.sock", false, "tcp", "127.0.0.1:2375", "/var/run/docker.sock"},
+		{"/tmp/pg.sock:db.internal:5432", false, "unix", "/tmp/pg.sock", "db.internal:5432"},
+		{"/tmp/agent.sock:localhost:3000", true, "unix", "/tmp/agent.sock", "localhost:3000"},
+		{"0.0.0.0:8080:/run/app.sock", true, "tcp", "0.0.0.0:8080", "/run/app.sock"},
+	}
+
+	for _, c := range cases {
+		parse := parseLocalForward
+		if c.remote {
+			parse = parseRemoteForward
+		}
+		f, err := parse(c.spec)
+		if err != nil {
+			t.Fatalf("%s: %v", c.spec, err)
+		}
+		if f.listenNetwork() != c.network || f.listenAddress() != c.listen || f.targetAddress() != c.target {
+			t.Errorf("%s: got %s %s -> %s", c.spec, f.listenNetwork(), f.listenAddress(), f.targetAddress())
+		}
+	}
+
+	for _, spec := range []string{"/var/run/docker.sock", "a:/tmp/x.sock:/y.sock"} {
+		if _, err := parseLocalForward(spec); err == nil {
+			t.Errorf("%s: expected error", spec)
+		}
+	}
+}
+
+func TestParseDynamicForward(t *testing.T) {
+	f, err := parseDynamicForward("1080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.listenAddress() != "127.0.0.1:1080" {
+		t.Errorf("got %s", f.listenAddress())
+	}
+
+	if _, err := parseDynamicForward("0.0.0.0:x"); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestServeSocks5(t *testing.T) {
+	echo := startTestProxy(t, func(conn net.Conn) bool { return true })
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	var requested string
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		serveSocks5(conn, func(network, addr string) (net.Conn, error) {
+			requested = addr
+			return net.Dial(network, echo.address())
+		})
+	}()
+
+	dialer, err := proxy.SOCKS5("tcp", ln.Addr().String(), nil, proxy.Direct)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := dialer.Dial("tcp", "dashboard.internal:8443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEcho(t, conn)
+
+	if requested != "dashboard.internal:8443" {
+		t.Errorf("unexpected target %s", requested)
+	}
+}
+
+func TestParseTunnelArgs(t *testing.T) {
+	tests := []struct {
+		args     []string
+		alias    string
+		forwards int
+		wantErr  bool
+	}{
+		{[]string{"db01", "-L", "5432:127.0.0.1:5432"}, "db01", 1, false},
+		{[]string{"-L", "5432:127.0.0.1:5432", "-D", "1080", "db01"}, "db01", 2, false},
+		{[]string{"-L", "5432:127.0.0.1:5432"}, "", 0, true},
+		{[]string{"db01", "extra"}, "", 0, true},
+	}
+
+	for _, tt := range tests {
+		cliForwards = nil
+		alias, err := parseTunnelArgs(tt.args)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseTunnelArgs(%v) error = %v, wantErr %v", tt.args, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		if alias != tt.alias || len(cliForwards) != tt.forwards {
+			t.Errorf("parseTunnelArgs(%v) = %s with %d forwards", tt.args, alias, len(cliForwards))
+		}
+	}
+	cliForwards = nil
+}
+
+// 轮询等待条件成立
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (fw *forwarder) currentClient() *ssh.Client {
+	fw.mu.RLock()
+	defer fw.mu.RUnlock()
+	return fw.client
+}
+
+// 连接断开后自动重连，首次连接提示已建立，之后提示已重新连接
+func TestRunTunnel_reconnect(t *testing.T) {
+	server := startTestSSHServer(t)
+	fw := newForwarder(nil)
+	stop := make(chan struct{})
+
+	stdout, _ := captureOutput(t, func() {
+		done := make(chan error, 1)
+		go func() { done <- runTunnel(server, fw, stop) }()
+
+		waitFor(t, "tunnel established", func() bool { return fw.currentClient() != nil })
+		first := fw.currentClient()
+		first.Close()
+		waitFor(t, "tunnel reconnected", func() bool {
+			client := fw.currentClient()
+			return client != nil && client != first
+		})
+
+		close(stop)
+		if err := <-done; err != nil {
+			t.Errorf("runTunnel: %v", err)
+		}
+	})
+
+	established := strings.Index(stdout, "隧道已建立")
+	reconnected := strings.Index(stdout, "已重新连接")
+	if established < 0 || reconnected < established || strings.Count(stdout, "隧道已建立") != 1 {
+		t.Errorf("unexpected tunnel log:\n%s", stdout)
+	}
+	if fw.currentClient() != nil {
+		t.Error("client should be cleared after stop")
+	}
+}
+
+// 首次连接时服务器拒绝远程监听，直接返回错误而不是无限重试
+func TestRunTunnel_remoteListenDenied(t *testing.T) {
+	server := startTestSSHServer(t)
+	fw := newForwarder([]Forward{{Type: ForwardTypeRemote, RemotePort: 1, LocalPort: 8080}})
+	stop := make(chan struct{})
+	defer close(stop)
+
+	done := make(chan error, 1)
+	go func() { done <- runTunnel(server, fw, stop) }()
+
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "服务器监听") {
+			t.Errorf("unexpected error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("runTunnel should fail when the remote listen is denied")
+	}
+}
+
+// 在Unix套接字上启动回显服务
+func startEchoSocket(t *testing.T, path string) {
+	t.Helper()
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+}
+
+// 本地转发经真实的SSH连接到达TCP与Unix套接字目标，重连后本地监听继续可用
+func TestForwarder_local(t *testing.T) {
+	echo := startTestProxy(t, func(conn net.Conn) bool { return true })
+	dir := t.TempDir()
+	remoteSocket := filepath.Join(dir, "remote.sock")
+	startEchoSocket(t, remoteSocket)
+	localSocket := filepath.Join(dir, "local.sock")
+
+	fw := newForwarder([]Forward{
+		{Type: ForwardTypeLocal, RemoteHost: "127.0.0.1", RemotePort: echo.Port},
+		{Type: ForwardTypeLocal, LocalSocket: localSocket, RemoteSocket: remoteSocket},
+	})
+	if err := fw.start(); err != nil {
+		t.Fatal(err)
+	}
+	defer fw.close()
+	tcpAddr := fw.listeners[0].Addr().String()
+
+	assertForwarded := func(stage string) {
+		t.Helper()
+		for _, target := range [][2]string{{"tcp", tcpAddr}, {"unix", localSocket}} {
+			conn, err := net.Dial(target[0], target[1])
+			if err != nil {
+				t.Fatalf("%s: dial %s: %v", stage, target[1], err)
+			}
+			assertEcho(t, conn)
+		}
+	}
+
+	// 尚未设置SSH连接时，本地连接被直接关闭
+	conn, err := net.Dial("tcp", tcpAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("expected connection to be closed without a client, got %v", err)
+	}
+	conn.Close()
+
+	server := startTestSSHServer(t)
+	client, err := server.GetSshClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fw.setClient(client); err != nil {
+		t.Fatal(err)
+	}
+	assertForwarded("first connection")
+
+	client.Close()
+	_ = fw.setClient(nil)
+	client, err = server.GetSshClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if err := fw.setClient(client); err != nil {
+		t.Fatal(err)
+	}
+	assertForwarded("after reconnect")
+}
+
+// 远程转发经真实的SSH连接将服务器上TCP端口与Unix套接字的连接转发到本地，重连后重新建立监听
+func TestForwarder_remote(t *testing.T) {
+	echo := startTestProxy(t, func(conn net.Conn) bool { return true })
+	dir := t.TempDir()
+	localSocket := filepath.Join(dir, "local.sock")
+	startEchoSocket(t, localSocket)
+	remoteSocket := filepath.Join(dir, "remote.sock")
+
+	fw := newForwarder([]Forward{
+		{Type: ForwardTypeRemote, LocalHost: "127.0.0.1", LocalPort: echo.Port},
+		{Type: ForwardTypeRemote, RemoteSocket: remoteSocket, LocalSocket: localSocket},
+	})
+	defer fw.close()
+
+	server := startTestSSHServer(t)
+	assertForwarded := func(stage string) {
+		t.Helper()
+		client, err := server.GetSshClient()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := fw.setClient(client); err != nil {
+			t.Fatalf("%s: %v", stage, err)
+		}
+
+		fw.mu.RLock()
+		tcpAddr := fw.remoteListeners[0].Addr().String()
+		fw.mu.RUnlock()
+		for _, target := range [][2]string{{"tcp", tcpAddr}, {"unix", remoteSocket}} {
+			conn, err := net.Dial(target[0], target[1])
+			if err != nil {
+				t.Fatalf("%s: dial %s: %v", stage, target[1], err)
+			}
+			assertEcho(t, conn)
+		}
+
+		client.Close()
+		_ = fw.setClient(nil)
+		// 服务器在连接断开后才关闭旧的监听
+		waitFor(t, "remote socket removed", func() bool {
+			_, err := os.Stat(remoteSocket)
+			return os.IsNotExist(err)
+		})
+	}
+
+	assertForwarded("first connection")
+	assertForwarded("after reconnect")
+}
@@ -6,6 +6,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"regexp"
+	"strings"
 	"sync"
 	"time"
 )
@@ -54,7 +56,7 @@ func loadConfig(configFile string) (*Config, error) {
 	cacheMutex.RUnlock()
 
 	if exists && entry.config != nil && entry.modTime.Equal(modTime) {
-		utils.Logf("使用缓存的配置文件: %s", configFile)
+		utils.Debugf("使用缓存的配置文件: %s", configFile)
 
 		cacheMutex.Lock()
 		entry.lastUsed = time.Now()
@@ -112,6 +114,13 @@ func loadConfig(configFile string) (*Config, error) {
 
 // validate 验证配置
 func (cfg *Config) validate() error {
+	if err := validateOptions(cfg.Options); err != nil {
+		return ConfigValidationError{
+			Field: "options",
+			Msg:   err.Error(),
+		}
+	}
+
 	// 验证服务器配置
 	for i, server := range cfg.Servers {
 		if err := server.validate(); err != nil {
@@ -122,6 +131,15 @@ func (cfg *Config) validate() error {
 		}
 	}
 
+	if cfg.Proxy != nil {
+		if err := cfg.Proxy.validate(); err != nil {
+			return ConfigValidationError{
+				Field: "proxy",
+				Msg:   err.Error(),
+			}
+		}
+	}
+
 	// 验证组配置
 	for i, group := range cfg.Groups {
 		if err := group.validate(); err != nil {
@@ -149,15 +167,134 @@ func (s *Server) validate() error {
 	if s.Port <= 0 || s.Port > 65535 {
 		return fmt.Errorf("端口号必须在1-65535之间")
 	}
-	if s.Method != "password" && s.Method != "key" {
-		return fmt.Errorf("认证方法必须是 'password' 或 'key'")
+	for _, method := range s.authMethodNames() {
+		switch method {
+		case "password", "agent", "keyboard-interactive":
+		case "key":
+			if s.Key == "" {
+				return fmt.Errorf("使用密钥认证时，密钥路径不能为空")
+			}
+		default:
+			return fmt.Errorf("认证方法必须是 'password'、'key'、'agent' 或 'keyboard-interactive'")
+		}
+	}
+	if len(s.ProxyJump) > 0 && s.ProxyCommand != "" {
+		return fmt.Errorf("proxy_jump 与 proxy_command 不能同时配置")
 	}
-	if s.Method == "key" && s.Key == "" {
-		return fmt.Errorf("使用密钥认证时，密钥路径不能为空")
+	if err := validateOptions(s.Options); err != nil {
+		return err
+	}
+	for i := range s.Forwards {
+		if err := s.Forwards[i].validate(); err != nil {
+			return fmt.Errorf("端口转发[%d]配置错误: %w", i, err)
+		}
+	}
+	for i := range s.Expect {
+		if err := s.Expect[i].validate(); err != nil {
+			return fmt.Errorf("自动应答规则[%d]配置错误: %w", i, err)
+		}
+	}
+	for i, command := range s.Startup {
+		if strings.TrimSpace(command) == "" {
+			return fmt.Errorf("启动命令[%d]不能为空", i)
+		}
+	}
+	if strings.TrimSpace(s.RemoteCommand) != "" && len(s.Startup) > 0 {
+		return fmt.Errorf("remote_command 与 startup 不能同时配置")
+	}
+	if s.StartupPrompt != "" {
+		if _, err := regexp.Compile(s.StartupPrompt); err != nil {
+			return fmt.Errorf("startup_prompt 不是有效的正则表达式: %w", err)
+		}
+	}
+	if s.Proxy != nil {
+		if !s.Proxy.isNone() && (len(s.ProxyJump) > 0 || s.ProxyCommand != "") {
+			return fmt.Errorf("proxy 不能与 proxy_jump 或 proxy_command 同时配置")
+		}
+		if err := s.Proxy.validate(); err != nil {
+			return fmt.Errorf("代理配置错误: %w", err)
+		}
+	}
+	for _, jump := range s.ProxyJump {
+		if strings.TrimSpace(jump) == "" {
+			return fmt.Errorf("跳板机名称不能为空")
+		}
+	}
+	if s.TotpSecret != "" {
+		if _, err := utils.GenerateTOTP(s.TotpSecret, time.Now()); err != nil {
+			return fmt.Errorf("totp_secret 无效: %w", err)
+		}
 	}
 	return nil
 }
 
+// validateOptions 验证连接选项
+func validateOptions(options map[string]interface{}) error {
+	if val, ok := options["Compression"]; ok {
+		if b, ok := toBool(val); ok && b {
+			return fmt.Errorf("不支持 Compression：golang.org/x/crypto/ssh 未实现 zlib 压缩，请删除该选项或设置为 false")
+		}
+	}
+
+	for _, name := range []string{"ConnectTimeout", "ServerAliveInterval", "ServerAliveCountMax"} {
+		val, ok := options[name]
+		if !ok || val == nil {
+			continue
+		}
+		n, ok := val.(float64)
+		if !ok || n < 0 {
+			return fmt.Errorf("选项 %s 必须是非负整数", name)
+		}
+	}
+
+	for _, name := range []string{"TCPKeepAlive", "ForwardX11"} {
+		if val, ok := options[name]; ok {
+			if _, ok := toBool(val); !ok {
+				return fmt.Errorf("选项 %s 必须是布尔值", name)
+			}
+		}
+	}
+
+	if val, ok := options["SetEnv"]; ok {
+		env, ok := val.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("选项 SetEnv 必须是对象，例如 {\"DEPLOY_ENV\": \"prod\"}")
+		}
+		for name, v := range env {
+			if name == "" || strings.ContainsAny(name, "= ") {
+				return fmt.Errorf("选项 SetEnv 中的变量名无效: %q", name)
+			}
+			if _, ok := v.(string); !ok {
+				return fmt.Errorf("选项 SetEnv 中 %s 的值必须是字符串", name)
+			}
+		}
+	}
+
+	if val, ok := options["SendEnv"]; ok {
+		switch patterns := val.(type) {
+		case string:
+		case []interface{}:
+			for _, p := range patterns {
+				if _, ok := p.(string); !ok {
+					return fmt.Errorf("选项 SendEnv 必须是字符串数组")
+				}
+			}
+		default:
+			return fmt.Errorf("选项 SendEnv 必须是字符串数组")
+		}
+	}
+
+	if val, ok := options["ForwardAgent"]; ok {
+		if s, isString := val.(string); !isString || !strings.EqualFold(strings.TrimSpace(s), ForwardAgentAsk) {
+			if _, ok := toBool(val); !ok {
+				return fmt.Errorf("选项 ForwardAgent 必须是布尔值或 \"ask\"")
+			}
+		}
+	}
+
+	return nil
+}
+
 // validate 验证组配置
 func (g *Group) validate() error {
 	if g.GroupName == "" {
@@ -173,6 +310,10 @@ func (g *Group) validate() error {
 		}
 	}
 
+	if g.Proxy != nil && !g.Proxy.isNone() && g.ProxyCommand != "" {
+		return fmt.Errorf("proxy 与 proxy_command 不能同时配置")
+	}
+
 	if g.Proxy != nil {
 		if err := g.Proxy.validate(); err != nil {
 			return fmt.Errorf("代理配置错误: %w", err)
@@ -187,6 +328,14 @@ func (p *Proxy) validate() error {
 	if p.Type == "" {
 		return fmt.Errorf("代理类型不能为空")
 	}
+	if p.isNone() {
+		return nil
+	}
+	switch p.normalizedType() {
+	case ProxyTypeSocks5, ProxyTypeSocks4, ProxyTypeSocks4a, ProxyTypeHttp, ProxyTypeHttps:
+	default:
+		return fmt.Errorf("不支持的代理类型: %s（可选 SOCKS5、SOCKS4、SOCKS4A、HTTP、HTTPS）", p.Type)
+	}
 	if p.Server == "" {
 		return fmt.Errorf("代理服务器地址不能为空")
 	}
@@ -0,0 +1,438 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// 连接路由，决定如何到达目标服务器
+type connectionRoute struct {
+	jumpHosts []string
+	command   string
+	proxy     *Proxy
+}
+
+func (r connectionRoute) isDirect() bool {
+	return len(r.jumpHosts) == 0 && r.command == "" && r.proxy == nil
+}
+
+func (r connectionRoute) String() string {
+	switch {
+	case len(r.jumpHosts) > 0:
+		return "jump:" + strings.Join(r.jumpHosts, ",")
+	case r.command != "":
+		return "command:" + r.command
+	case r.proxy != nil:
+		return strings.ToLower(string(r.proxy.normalizedType())) + "://" + r.proxy.User + "@" + r.proxy.address()
+	default:
+		return "direct"
+	}
+}
+
+// 确定服务器的有效路由
+// 优先级：服务器 > 分组 > 全局；同一层级内 proxy_jump > proxy_command > proxy；
+// proxy 设置为 "none" 时直连，并忽略更低层级的配置
+func (server *Server) route() connectionRoute {
+	if len(server.ProxyJump) > 0 {
+		return connectionRoute{jumpHosts: server.ProxyJump}
+	}
+	if server.ProxyCommand != "" {
+		return connectionRoute{command: expandProxyCommand(server.ProxyCommand, server)}
+	}
+	if server.Proxy != nil {
+		return proxyRoute(server.Proxy)
+	}
+
+	if group := server.group; group != nil {
+		if group.ProxyCommand != "" {
+			return connectionRoute{command: expandProxyCommand(group.ProxyCommand, server)}
+		}
+		if group.Proxy != nil {
+			return proxyRoute(group.Proxy)
+		}
+	}
+
+	if server.cfg != nil && server.cfg.Proxy != nil {
+		return proxyRoute(server.cfg.Proxy)
+	}
+
+	return connectionRoute{}
+}
+
+func proxyRoute(p *Proxy) connectionRoute {
+	if p.isNone() {
+		return connectionRoute{}
+	}
+	return connectionRoute{proxy: p}
+}
+
+// 是否显式禁用代理
+func (p *Proxy) isNone() bool {
+	return p.normalizedType() == ProxyTypeNone
+}
+
+// 支持使用字符串 "none" 显式禁用代理
+func (p *Proxy) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		if ProxyType(strings.ToUpper(strings.TrimSpace(name))) != ProxyTypeNone {
+			return fmt.Errorf("无效的代理配置: %q（仅支持 \"none\" 或代理对象）", name)
+		}
+		*p = Proxy{Type: ProxyTypeNone}
+		return nil
+	}
+
+	type plain Proxy
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode((*plain)(p))
+}
+
+func (p Proxy) MarshalJSON() ([]byte, error) {
+	if p.isNone() {
+		return json.Marshal("none")
+	}
+
+	type plain Proxy
+	return json.Marshal(plain(p))
+}
+
+// 代理类型不区分大小写
+func (p *Proxy) normalizedType() ProxyType {
+	return ProxyType(strings.ToUpper(strings.TrimSpace(string(p.Type))))
+}
+
+// 代理服务器地址
+func (p *Proxy) address() string {
+	return net.JoinHostPort(p.Server, strconv.Itoa(p.Port))
+}
+
+// 创建代理拨号器，forward 用于连接代理服务器本身
+// timeout 限制与 HTTP/SOCKS4 代理握手的时间，为 0 时不限制
+func (p *Proxy) dialer(forward proxy.Dialer, timeout time.Duration) (proxy.Dialer, error) {
+	switch p.normalizedType() {
+	case ProxyTypeSocks5:
+		var auth *proxy.Auth
+		if p.User != "" {
+			auth = &proxy.Auth{
+				User:     p.User,
+				Password: p.Password,
+			}
+		}
+
+		dialer, err := proxy.SOCKS5("tcp", p.address(), auth, forward)
+		if err != nil {
+			return nil, fmt.Errorf("创建SOCKS5代理失败: %w", err)
+		}
+		return dialer, nil
+
+	case ProxyTypeSocks4, ProxyTypeSocks4a:
+		return &socks4Dialer{proxy: p, forward: forward, timeout: timeout, remoteResolve: p.normalizedType() == ProxyTypeSocks4a}, nil
+
+	case ProxyTypeHttp, ProxyTypeHttps:
+		return &httpConnectDialer{proxy: p, forward: forward, timeout: timeout, tls: p.normalizedType() == ProxyTypeHttps}, nil
+
+	default:
+		return nil, fmt.Errorf("不支持的代理类型: %s", p.Type)
+	}
+}
+
+// HTTP/HTTPS CONNECT 代理
+type httpConnectDialer struct {
+	proxy   *Proxy
+	forward proxy.Dialer
+	timeout time.Duration
+	tls     bool
+}
+
+func (d *httpConnectDialer) Dial(network, addr string) (net.Conn, error) {
+	conn, err := d.forward.Dial("tcp", d.proxy.address())
+	if err != nil {
+		return nil, fmt.Errorf("连接HTTP代理失败: %w", err)
+	}
+	setHandshakeDeadline(conn, d.timeout)
+
+	if d.tls {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: d.proxy.Server})
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("HTTPS代理TLS握手失败: %w", err)
+		}
+		conn = tlsConn
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if d.proxy.User != "" {
+		credential := base64.StdEncoding.EncodeToString([]byte(d.proxy.User + ":" + d.proxy.Password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credential)
+	}
+
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("发送CONNECT请求失败: %w", err)
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("读取代理响应失败: %w", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		if resp.StatusCode == http.StatusProxyAuthRequired {
+			return nil, fmt.Errorf("HTTP代理认证失败: %s", resp.Status)
+		}
+		return nil, fmt.Errorf("HTTP代理拒绝连接: %s", resp.Status)
+	}
+
+	_ = conn.SetDeadline(time.Time{})
+
+	// 代理可能在响应后立即发送了部分数据（如SSH版本号），需保留在缓冲区中
+	if reader.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, reader: reader}, nil
+	}
+	return conn, nil
+}
+
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+// SOCKS4/SOCKS4a 代理
+// SOCKS4 只支持 IPv4，主机名在本地解析；SOCKS4a 由代理服务器解析主机名
+type socks4Dialer struct {
+	proxy         *Proxy
+	forward       proxy.Dialer
+	timeout       time.Duration
+	remoteResolve bool
+}
+
+func (d *socks4Dialer) Dial(network, addr string) (net.Conn, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("解析目标地址失败: %w", err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 || port > 65535 {
+		return nil, fmt.Errorf("目标端口无效: %s", portStr)
+	}
+
+	var ip4 net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 = ip.To4(); ip4 == nil {
+			return nil, errors.New("SOCKS4代理不支持IPv6地址")
+		}
+	} else if !d.remoteResolve {
+		ips, err := net.LookupIP(host)
+		if err != nil {
+			return nil, fmt.Errorf("解析主机名失败: %w", err)
+		}
+		for _, ip := range ips {
+			if ip4 = ip.To4(); ip4 != nil {
+				break
+			}
+		}
+		if ip4 == nil {
+			return nil, fmt.Errorf("主机 %s 没有IPv4地址", host)
+		}
+	}
+
+	// VN(4) CD(1:CONNECT) DSTPORT DSTIP USERID NULL [HOST NULL]
+	req := []byte{4, 1, byte(port >> 8), byte(port)}
+	if ip4 != nil {
+		req = append(req, ip4...)
+	} else {
+		req = append(req, 0, 0, 0, 1)
+	}
+	req = append(req, []byte(d.proxy.User)...)
+	req = append(req, 0)
+	if ip4 == nil {
+		req = append(req, []byte(host)...)
+		req = append(req, 0)
+	}
+
+	conn, err := d.forward.Dial("tcp", d.proxy.address())
+	if err != nil {
+		return nil, fmt.Errorf("连接SOCKS4代理失败: %w", err)
+	}
+	setHandshakeDeadline(conn, d.timeout)
+
+	if _, err := conn.Write(req); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("发送SOCKS4请求失败: %w", err)
+	}
+
+	resp := make([]byte, 8)
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("读取SOCKS4响应失败: %w", err)
+	}
+	if resp[1] != 0x5a {
+		conn.Close()
+		return nil, fmt.Errorf("SOCKS4代理拒绝连接（状态码: 0x%02x）", resp[1])
+	}
+
+	_ = conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// 为代理握手设置截止时间，避免代理无响应时一直阻塞，握手完成后需清除
+func setHandshakeDeadline(conn net.Conn, timeout time.Duration) {
+	if timeout > 0 {
+		_ = conn.SetDeadline(time.Now().Add(timeout))
+	}
+}
+
+// 展开 ProxyCommand 中的占位符：%h 主机、%p 端口、%r 用户、%% 百分号
+func expandProxyCommand(command string, server *Server) string {
+	var builder strings.Builder
+	for i := 0; i < len(command); i++ {
+		if command[i] != '%' || i == len(command)-1 {
+			builder.WriteByte(command[i])
+			continue
+		}
+
+		i++
+		switch command[i] {
+		case 'h':
+			builder.WriteString(shellQuote(server.Ip))
+		case 'p':
+			builder.WriteString(strconv.Itoa(server.Port))
+		case 'r':
+			builder.WriteString(shellQuote(server.User))
+		case '%':
+			builder.WriteByte('%')
+		default:
+			builder.WriteByte('%')
+			builder.WriteByte(command[i])
+		}
+	}
+	return builder.String()
+}
+
+// 启动已展开占位符的 ProxyCommand，以其标准输入输出作为SSH传输通道
+func dialProxyCommand(command string, server *Server) (net.Conn, error) {
+	cmd := exec.Command("sh", "-c", "exec "+command)
+	cmd.Stderr = os.Stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("创建 ProxyCommand 输入管道失败: %w", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("创建 ProxyCommand 输出管道失败: %w", err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("启动 ProxyCommand 失败: %w", err)
+	}
+
+	return &commandConn{
+		cmd:    cmd,
+		stdin:  stdin,
+		stdout: stdout,
+		remote: commandAddr(server.address()),
+	}, nil
+}
+
+type commandAddr string
+
+func (a commandAddr) Network() string { return "proxy-command" }
+func (a commandAddr) String() string  { return string(a) }
+
+// 将子进程的标准输入输出包装为 net.Conn
+type commandConn struct {
+	cmd       *exec.Cmd
+	stdin     io.WriteCloser
+	stdout    io.ReadCloser
+	remote    commandAddr
+	closeOnce sync.Once
+
+	// 管道无法中断单次读写，超过截止时间时结束 ProxyCommand 进程
+	mu            sync.Mutex
+	readDeadline  *time.Timer
+	writeDeadline *time.Timer
+}
+
+func (c *commandConn) Read(b []byte) (int, error)  { return c.stdout.Read(b) }
+func (c *commandConn) Write(b []byte) (int, error) { return c.stdin.Write(b) }
+
+func (c *commandConn) Close() error {
+	c.closeOnce.Do(func() {
+		_ = c.stdin.Close()
+		if c.cmd.Process != nil {
+			_ = c.cmd.Process.Kill()
+		}
+		_ = c.cmd.Wait()
+	})
+	return nil
+}
+
+// 远端地址使用目标 host:port，便于 known_hosts 校验
+func (c *commandConn) LocalAddr() net.Addr  { return commandAddr("127.0.0.1:0") }
+func (c *commandConn) RemoteAddr() net.Addr { return c.remote }
+
+func (c *commandConn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.setDeadline(&c.readDeadline, t)
+	c.setDeadline(&c.writeDeadline, t)
+	return nil
+}
+
+func (c *commandConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.setDeadline(&c.readDeadline, t)
+	return nil
+}
+
+func (c *commandConn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.setDeadline(&c.writeDeadline, t)
+	return nil
+}
+
+// 重新设置截止时间，t 为零值时取消
+func (c *commandConn) setDeadline(timer **time.Timer, t time.Time) {
+	if *timer != nil {
+		(*timer).Stop()
+		*timer = nil
+	}
+	if t.IsZero() {
+		return
+	}
+	*timer = time.AfterFunc(time.Until(t), func() { _ = c.Close() })
+}
@@ -0,0 +1,250 @@
+package app
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 启动一个本地代理，handle 完成握手后回显数据
+func startTestProxy(t *testing.T, handle func(conn net.Conn) bool) *Proxy {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				if handle(conn) {
+					_, _ = io.Copy(conn, conn)
+				}
+			}()
+		}
+	}()
+
+	addr := ln.Addr().(*net.TCPAddr)
+	return &Proxy{Server: "127.0.0.1", Port: addr.Port}
+}
+
+func assertEcho(t *testing.T, conn net.Conn) {
+	t.Helper()
+	defer conn.Close()
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "ping" {
+		t.Fatalf("unexpected echo %q", buf)
+	}
+}
+
+func TestProxy_HttpConnect(t *testing.T) {
+	p := startTestProxy(t, func(conn net.Conn) bool {
+		req, err := http.ReadRequest(bufio.NewReader(conn))
+		if err != nil {
+			return false
+		}
+		want := "Basic " + base64.StdEncoding.EncodeToString([]byte("user:secret"))
+		if req.Method != http.MethodConnect || req.Host != "example.test:22" || req.Header.Get("Proxy-Authorization") != want {
+			_, _ = conn.Write([]byte("HTTP/1.1 407 Proxy Authentication Required\r\n\r\n"))
+			return false
+		}
+		_, _ = conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+		return true
+	})
+	p.Type = "http"
+	p.User = "user"
+	p.Password = "secret"
+
+	dialer, err := p.dialer(&net.Dialer{}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := dialer.Dial("tcp", "example.test:22")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEcho(t, conn)
+
+	p.Password = "wrong"
+	if _, err := dialer.Dial("tcp", "example.test:22"); err == nil {
+		t.Fatal("expected authentication failure")
+	}
+}
+
+func TestProxy_Socks4a(t *testing.T) {
+	p := startTestProxy(t, func(conn net.Conn) bool {
+		r := bufio.NewReader(conn)
+		head := make([]byte, 8)
+		if _, err := io.ReadFull(r, head); err != nil {
+			return false
+		}
+		user, _ := r.ReadString(0)
+		host, _ := r.ReadString(0)
+		port := int(head[2])<<8 | int(head[3])
+		if head[0] != 4 || head[1] != 1 || user != "user\x00" || host != "example.test\x00" || port != 2222 {
+			_, _ = conn.Write([]byte{0, 0x5b, 0, 0, 0, 0, 0, 0})
+			return false
+		}
+		_, _ = conn.Write([]byte{0, 0x5a, 0, 0, 0, 0, 0, 0})
+		return true
+	})
+	p.Type = ProxyTypeSocks4a
+	p.User = "user"
+
+	dialer, err := p.dialer(&net.Dialer{}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := dialer.Dial("tcp", net.JoinHostPort("example.test", strconv.Itoa(2222)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEcho(t, conn)
+}
+
+// 代理接受连接后不响应时，握手在超时后失败
+func TestProxy_handshakeTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	p := startTestProxy(t, func(conn net.Conn) bool {
+		<-release
+		return false
+	})
+
+	for _, typ := range []ProxyType{ProxyTypeHttp, ProxyTypeSocks4a} {
+		p.Type = typ
+		dialer, err := p.dialer(&net.Dialer{}, 100*time.Millisecond)
+		if err != nil {
+			t.Fatal(err)
+		}
+		start := time.Now()
+		if _, err := dialer.Dial("tcp", "example.test:22"); err == nil {
+			t.Errorf("%s: expected handshake timeout", typ)
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("%s: handshake took %v", typ, elapsed)
+		}
+	}
+}
+
+func TestProxy_validate(t *testing.T) {
+	for _, typ := range []ProxyType{"socks5", "SOCKS4", "socks4a", "HTTP", "https"} {
+		p := Proxy{Type: typ, Server: "proxy.test", Port: 8080}
+		if err := p.validate(); err != nil {
+			t.Errorf("%s: %v", typ, err)
+		}
+	}
+	p := Proxy{Type: "ftp", Server: "proxy.test", Port: 8080}
+	if err := p.validate(); err == nil {
+		t.Error("expected unsupported type error")
+	}
+}
+
+func TestExpandProxyCommand(t *testing.T) {
+	server := &Server{Ip: "10.0.0.5", Port: 2222, User: "o'ps"}
+	got := expandProxyCommand("nc -X connect %h %p # %r 100%% %x", server)
+	want := `nc -X connect '10.0.0.5' 2222 # 'o'\''ps' 100% %x`
+	if got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestDialProxyCommand(t *testing.T) {
+	server := &Server{Ip: "10.0.0.5", Port: 22, User: "ops"}
+	conn, err := dialProxyCommand("cat", server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn.RemoteAddr().String() != "10.0.0.5:22" {
+		t.Errorf("unexpected remote addr %s", conn.RemoteAddr())
+	}
+	assertEcho(t, conn)
+
+	// 超过截止时间后结束进程，阻塞的读取随之返回
+	_ = conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	done := make(chan error, 1)
+	go func() {
+		_, err := conn.Read(make([]byte, 1))
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("expected read error after deadline")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("read was not interrupted by the deadline")
+	}
+}
+
+func TestServer_routePrecedence(t *testing.T) {
+	data := []byte(`{
+		"proxy": {"type": "SOCKS5", "server": "global.test", "port": 1080},
+		"servers": [
+			{"name": "a", "ip": "10.0.0.1", "port": 22, "user": "u", "password": "p", "method": "password", "alias": "a"},
+			{"name": "b", "ip": "10.0.0.1", "port": 22, "user": "u", "password": "p", "method": "password", "alias": "b", "proxy": "none"}
+		],
+		"groups": [{
+			"group_name": "g", "prefix": "g",
+			"proxy": {"type": "HTTP", "server": "group.test", "port": 3128},
+			"servers": [
+				{"name": "c", "ip": "10.0.0.3", "port": 22, "user": "u", "password": "p", "method": "password", "alias": "c"},
+				{"name": "d", "ip": "10.0.0.4", "port": 22, "user": "u", "password": "p", "method": "password", "alias": "d",
+				 "proxy": {"type": "SOCKS4", "server": "server.test", "port": 1081}},
+				{"name": "e", "ip": "10.0.0.5", "port": 22, "user": "u", "password": "p", "method": "password", "alias": "e", "proxy": "none"}
+			]
+		}]
+	}`)
+
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.validate(); err != nil {
+		t.Fatal(err)
+	}
+	cfg.createServerIndex()
+
+	cases := map[string]string{
+		"a": "socks5://@global.test:1080",
+		"b": "direct",
+		"c": "http://@group.test:3128",
+		"d": "socks4://@server.test:1081",
+		"e": "direct",
+	}
+	for alias, want := range cases {
+		if got := cfg.serverIndex[alias].server.route().String(); got != want {
+			t.Errorf("%s: route = %s, want %s", alias, got, want)
+		}
+	}
+
+	if cfg.serverIndex["a"].server.getConnectionKey() == cfg.serverIndex["b"].server.getConnectionKey() {
+		t.Error("connection keys must differ for different routes")
+	}
+
+	out, err := json.Marshal(cfg.Servers[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(out), `"proxy":"none"`) {
+		t.Errorf("expected proxy none to round-trip, got %s", out)
+	}
+}
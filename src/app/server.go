@@ -4,6 +4,7 @@ import (
 	"autossh/src/utils"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"os"
@@ -16,18 +17,25 @@ import (
 
 	"github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
-	"golang.org/x/crypto/ssh/knownhosts"
+	"golang.org/x/crypto/ssh/agent"
 	"golang.org/x/crypto/ssh/terminal"
-	"golang.org/x/net/proxy"
 )
 
 // 连接池管理
 var (
 	connectionPool = make(map[string]*ssh.Client)
+	pendingDials   = make(map[string]*pendingDial)
 	poolMutex      sync.RWMutex
 	poolCleanup    = time.NewTicker(5 * time.Minute)
 )
 
+// 正在建立的连接，完成后关闭 done
+type pendingDial struct {
+	done   chan struct{}
+	client *ssh.Client
+	err    error
+}
+
 func init() {
 	// 启动连接池清理协程
 	go func() {
@@ -55,21 +63,41 @@ func cleanupConnectionPool() {
 }
 
 type Server struct {
-	Name     string                 `json:"name"`
-	Ip       string                 `json:"ip"`
-	Port     int                    `json:"port"`
-	User     string                 `json:"user"`
-	Password string                 `json:"password"`
-	Method   string                 `json:"method"`
-	Key      string                 `json:"key"`
-	Options  map[string]interface{} `json:"options"`
-	Alias    string                 `json:"alias"`
-	Log      ServerLog              `json:"log"`
+	Name         string                 `json:"name"`
+	Ip           string                 `json:"ip"`
+	Port         int                    `json:"port"`
+	User         string                 `json:"user"`
+	Password     string                 `json:"password"`
+	Method       string                 `json:"method"`
+	Methods      []string               `json:"methods,omitempty"`
+	Key          string                 `json:"key"`
+	Cert         string                 `json:"cert,omitempty"`
+	TotpSecret   string                 `json:"totp_secret,omitempty"`
+	Options      map[string]interface{} `json:"options"`
+	Alias        string                 `json:"alias"`
+	Log          ServerLog              `json:"log"`
+	ProxyJump    []string               `json:"proxy_jump,omitempty"`
+	ProxyCommand string                 `json:"proxy_command,omitempty"`
+	Proxy        *Proxy                 `json:"proxy,omitempty"`
+	Forwards     []Forward              `json:"forwards,omitempty"`
+	Expect       []ExpectRule           `json:"expect,omitempty"`
+
+	// 登录后代替Shell执行的命令，或在Shell提示符出现后依次输入的命令（二者只能选其一）；
+	// keep_shell 为 true 时执行完毕后保留交互式Shell，否则结束会话
+	RemoteCommand string   `json:"remote_command,omitempty"`
+	Startup       []string `json:"startup,omitempty"`
+	StartupPrompt string   `json:"startup_prompt,omitempty"`
+	KeepShell     bool     `json:"keep_shell,omitempty"`
+
+	// 执行命令时 --sudo 使用的密码，可选择复用登录密码
+	SudoPassword         string `json:"sudo_password,omitempty"`
+	SudoUseLoginPassword bool   `json:"sudo_use_login_password,omitempty"`
 
 	termWidth  int
 	termHeight int
 	groupName  string
 	group      *Group
+	cfg        *Config
 }
 
 // 格式化，赋予默认值
@@ -78,11 +106,26 @@ func (server *Server) Format() {
 		server.Port = 22
 	}
 
-	if server.Method == "" {
+	if server.Method == "" && len(server.Methods) == 0 {
 		server.Method = "password"
 	}
 }
 
+// 获取认证方法列表，methods 按顺序依次尝试，未配置时使用 method
+// 名称统一去除空白并转为小写，校验与认证使用同一结果
+func (server *Server) authMethodNames() []string {
+	names := server.Methods
+	if len(names) == 0 {
+		names = []string{server.Method}
+	}
+
+	normalized := make([]string, len(names))
+	for i, name := range names {
+		normalized[i] = strings.ToLower(strings.TrimSpace(name))
+	}
+	return normalized
+}
+
 // 合并选项
 func (server *Server) MergeOptions(options map[string]interface{}, overwrite bool) {
 	if server.Options == nil {
@@ -134,80 +177,49 @@ func (server *Server) getConnectTimeout() time.Duration {
 	return 30 * time.Second // 默认30秒超时
 }
 
-func (server *Server) shouldSkipHostKeyCheck() bool {
-	if insecureSkipHostKeyCheck {
-		return true
-	}
-
-	if server.Options == nil {
-		return false
-	}
-
-	if val, ok := server.Options["InsecureSkipHostKeyChecking"]; ok {
-		if b, ok := toBool(val); ok {
-			return b
-		}
-	}
-	if val, ok := server.Options["SkipHostKeyCheck"]; ok {
-		if b, ok := toBool(val); ok {
-			return b
+// 获取数值类型的选项
+func (server *Server) getOptionInt(name string, defaultValue int) int {
+	if val, ok := server.Options[name]; ok && val != nil {
+		switch v := val.(type) {
+		case float64:
+			return int(v)
+		case int:
+			return v
 		}
 	}
-	if val, ok := server.Options["StrictHostKeyChecking"]; ok {
-		if b, ok := toBool(val); ok {
-			return !b
-		}
-		if s, ok := val.(string); ok {
-			switch strings.ToLower(strings.TrimSpace(s)) {
-			case "no", "false", "0", "off":
-				return true
-			}
-		}
-	}
-
-	return false
+	return defaultValue
 }
 
-func (server *Server) getKnownHostsFile() (string, error) {
-	if server.Options != nil {
-		if val, ok := server.Options["KnownHostsFile"]; ok {
-			if s, ok := val.(string); ok && strings.TrimSpace(s) != "" {
-				return utils.ParsePath(s)
-			}
-		}
-	}
-	return utils.ParsePath("~/.ssh/known_hosts")
+// 获取心跳间隔，未配置时不发送心跳
+func (server *Server) getServerAliveInterval() time.Duration {
+	return time.Duration(server.getOptionInt("ServerAliveInterval", 0)) * time.Second
 }
 
-func (server *Server) getHostKeyCallback() (ssh.HostKeyCallback, error) {
-	if server.shouldSkipHostKeyCheck() {
-		return ssh.InsecureIgnoreHostKey(), nil
-	}
-
-	knownHostsFile, err := server.getKnownHostsFile()
-	if err != nil {
-		return nil, fmt.Errorf("解析 KnownHostsFile 失败: %w", err)
+// 获取心跳最大未响应次数，默认3次
+func (server *Server) getServerAliveCountMax() int {
+	if n := server.getOptionInt("ServerAliveCountMax", 3); n > 0 {
+		return n
 	}
+	return 3
+}
 
-	if _, err := os.Stat(knownHostsFile); err != nil {
-		if os.IsNotExist(err) {
-			return nil, fmt.Errorf("known_hosts 文件不存在: %s（可使用 --insecure 跳过校验）", knownHostsFile)
+// 是否启用TCP保活，默认启用
+func (server *Server) getTCPKeepAlive() bool {
+	if val, ok := server.Options["TCPKeepAlive"]; ok {
+		if b, ok := toBool(val); ok {
+			return b
 		}
-		return nil, fmt.Errorf("读取 known_hosts 失败: %w", err)
 	}
+	return true
+}
 
-	cb, err := knownhosts.New(knownHostsFile)
-	if err != nil {
-		return nil, fmt.Errorf("解析 known_hosts 失败: %w", err)
+// 创建TCP拨号器，应用连接超时与TCP保活设置
+func (server *Server) netDialer() *net.Dialer {
+	dialer := &net.Dialer{Timeout: server.getConnectTimeout()}
+	if !server.getTCPKeepAlive() {
+		dialer.KeepAlive = -1
 	}
-
-	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
-		if err := cb(hostname, remote, key); err != nil {
-			fp := ssh.FingerprintSHA256(key)
-			return fmt.Errorf("SSH HostKey 校验失败: %s (%s): %w（指纹: %s，known_hosts: %s，可用 --insecure 跳过）", hostname, remote.String(), err, fp, knownHostsFile)
-		}
-		return nil
-	}, nil
+	return dialer
 }
 
 func toBool(v interface{}) (bool, bool) {
@@ -232,9 +244,44 @@ func toBool(v interface{}) (bool, bool) {
 	}
 }
 
-// 生成连接键用于连接池
+// 生成连接键用于连接池，经不同路由到达同一服务器的连接不能共用
 func (server *Server) getConnectionKey() string {
-	return fmt.Sprintf("%s@%s:%d", server.User, server.Ip, server.Port)
+	key := fmt.Sprintf("%s@%s:%d", server.User, server.Ip, server.Port)
+	if route := server.route(); !route.isDirect() {
+		key += " via " + route.String()
+	}
+	return key
+}
+
+// 生成SSH客户端配置
+// 握手完成后（无论成功与否）须调用 release 释放认证用到的资源，如 ssh-agent 连接
+func (server *Server) clientConfig() (config *ssh.ClientConfig, release func(), err error) {
+	auth, release, err := parseAuthMethods(server)
+	if err != nil {
+		return nil, nil, fmt.Errorf("解析认证方法失败: %w", err)
+	}
+
+	hostKeyCallback, err := server.getHostKeyCallback()
+	if err != nil {
+		release()
+		return nil, nil, err
+	}
+
+	return &ssh.ClientConfig{
+		User:            server.User,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         server.getConnectTimeout(),
+	}, release, nil
+}
+
+// 服务器地址 host:port
+func (server *Server) address() string {
+	// 默认端口为22
+	if server.Port == 0 {
+		server.Port = 22
+	}
+	return net.JoinHostPort(server.Ip, strconv.Itoa(server.Port))
 }
 
 // 从连接池获取或创建SSH Client - 优化版本
@@ -243,9 +290,9 @@ func (server *Server) GetSshClient() (*ssh.Client, error) {
 
 	// 尝试从连接池获取现有连接
 	poolMutex.RLock()
-	if client, exists := connectionPool[connectionKey]; exists {
-		poolMutex.RUnlock()
-
+	client, exists := connectionPool[connectionKey]
+	poolMutex.RUnlock()
+	if exists {
 		// 测试连接是否有效
 		session, err := client.NewSession()
 		if err == nil {
@@ -255,88 +302,210 @@ func (server *Server) GetSshClient() (*ssh.Client, error) {
 
 		// 连接无效，从池中移除
 		poolMutex.Lock()
-		delete(connectionPool, connectionKey)
+		if connectionPool[connectionKey] == client {
+			delete(connectionPool, connectionKey)
+		}
+		poolMutex.Unlock()
 		client.Close()
+	}
+
+	// 同一服务器只建立一个连接，并发调用等待该连接建立完成
+	poolMutex.Lock()
+	if client, exists := connectionPool[connectionKey]; exists {
 		poolMutex.Unlock()
-	} else {
-		poolMutex.RUnlock()
+		return client, nil
+	}
+	if dial, ok := pendingDials[connectionKey]; ok {
+		poolMutex.Unlock()
+		<-dial.done
+		return dial.client, dial.err
+	}
+	dial := &pendingDial{done: make(chan struct{})}
+	pendingDials[connectionKey] = dial
+	poolMutex.Unlock()
+
+	dial.client, dial.err = server.dialSshClient()
+
+	// 将新连接添加到池中
+	poolMutex.Lock()
+	if dial.err == nil {
+		connectionPool[connectionKey] = dial.client
 	}
+	delete(pendingDials, connectionKey)
+	poolMutex.Unlock()
+	close(dial.done)
 
-	// 创建新连接
-	auth, err := parseAuthMethods(server)
+	return dial.client, dial.err
+}
+
+// 按路由建立新的SSH连接
+func (server *Server) dialSshClient() (*ssh.Client, error) {
+	config, release, err := server.clientConfig()
 	if err != nil {
-		return nil, fmt.Errorf("解析认证方法失败: %w", err)
+		return nil, err
+	}
+	defer release()
+
+	addr := server.address()
+
+	route := server.route()
+	switch {
+	case len(route.jumpHosts) > 0:
+		return server.jumpSshClient(addr, config)
+	case route.command != "":
+		return server.proxyCommandSshClient(route.command, addr, config)
+	case route.proxy != nil:
+		return server.proxySshClient(route.proxy, addr, config)
+	default:
+		return server.directSshClient(addr, config)
 	}
+}
 
-	hostKeyCallback, err := server.getHostKeyCallback()
+// 直接建立TCP连接
+func (server *Server) directSshClient(addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	conn, err := server.netDialer().Dial("tcp", addr)
 	if err != nil {
 		return nil, err
 	}
 
-	config := &ssh.ClientConfig{
-		User:            server.User,
-		Auth:            auth,
-		HostKeyCallback: hostKeyCallback,
-		Timeout:         server.getConnectTimeout(),
+	return newSshClientConn(conn, addr, config)
+}
+
+func (server *Server) proxySshClient(p *Proxy, sshServerAddr string, sshConfig *ssh.ClientConfig) (client *ssh.Client, err error) {
+	dialer, err := p.dialer(server.netDialer(), server.getConnectTimeout())
+	if err != nil {
+		return nil, err
 	}
 
-	// 默认端口为22
-	if server.Port == 0 {
-		server.Port = 22
+	conn, err := dialer.Dial("tcp", sshServerAddr)
+	if err != nil {
+		return nil, fmt.Errorf("通过代理连接失败: %w", err)
 	}
 
-	addr := server.Ip + ":" + strconv.Itoa(server.Port)
+	return newSshClientConn(conn, sshServerAddr, sshConfig)
+}
 
-	var client *ssh.Client
-	if server.group != nil && server.group.Proxy != nil {
-		client, err = server.proxySshClient(server.group.Proxy, addr, config)
-	} else {
-		client, err = ssh.Dial("tcp", addr, config)
+// 通过 ProxyCommand 建立连接
+func (server *Server) proxyCommandSshClient(command string, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	conn, err := dialProxyCommand(command, server)
+	if err != nil {
+		return nil, err
 	}
 
+	return newSshClientConn(conn, addr, config)
+}
+
+// 在已建立的传输连接上完成SSH握手
+func newSshClientConn(conn net.Conn, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
 	if err != nil {
-		return nil, err
+		conn.Close()
+		return nil, fmt.Errorf("创建SSH客户端连接失败: %w", err)
 	}
 
-	// 将新连接添加到池中
-	poolMutex.Lock()
-	connectionPool[connectionKey] = client
-	poolMutex.Unlock()
+	return ssh.NewClient(c, chans, reqs), nil
+}
 
-	return client, nil
+// 解析跳板机，proxy_jump 中可使用服务器编号或别名
+func (server *Server) resolveJumpHosts() ([]*Server, error) {
+	if server.cfg == nil {
+		return nil, errors.New("无法解析跳板机：服务器未关联配置")
+	}
+
+	hops := make([]*Server, 0, len(server.ProxyJump))
+	for _, name := range server.ProxyJump {
+		index, ok := server.cfg.serverIndex[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("跳板机 %s 不存在", name)
+		}
+		if index.server == server {
+			return nil, fmt.Errorf("跳板机 %s 不能是服务器自身", name)
+		}
+		hops = append(hops, index.server)
+	}
+
+	// 第一跳按其自身的路由建立连接，沿链路检查是否存在循环引用
+	seen := map[*Server]bool{server: true}
+	for next := hops[0]; next != nil; {
+		if seen[next] {
+			return nil, fmt.Errorf("跳板机 %s 存在循环引用", next.Name)
+		}
+		seen[next] = true
+
+		if len(next.ProxyJump) == 0 {
+			break
+		}
+		index, ok := server.cfg.serverIndex[strings.TrimSpace(next.ProxyJump[0])]
+		if !ok {
+			break
+		}
+		next = index.server
+	}
+
+	return hops, nil
 }
 
-func (server *Server) proxySshClient(p *Proxy, sshServerAddr string, sshConfig *ssh.ClientConfig) (client *ssh.Client, err error) {
-	var dialer proxy.Dialer
-	switch p.Type {
-	case ProxyTypeSocks5:
-		var auth proxy.Auth
-		if p.User != "" {
-			auth = proxy.Auth{
-				User:     p.User,
-				Password: p.Password,
-			}
+// 通过跳板机链路建立连接，每一跳使用各自的认证方式和主机密钥校验
+func (server *Server) jumpSshClient(addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	hops, err := server.resolveJumpHosts()
+	if err != nil {
+		return nil, err
+	}
+
+	via, err := hops[0].GetSshClient()
+	if err != nil {
+		return nil, fmt.Errorf("连接跳板机 %s 失败: %w", hops[0].Name, err)
+	}
+
+	// 第一跳来自连接池，由连接池管理；后续各跳随目标连接一起关闭
+	var owned []*ssh.Client
+	closeOwned := func() {
+		for i := len(owned) - 1; i >= 0; i-- {
+			owned[i].Close()
 		}
+	}
 
-		dialer, err = proxy.SOCKS5("tcp", p.Server+":"+strconv.Itoa(p.Port), &auth, proxy.Direct)
+	for _, hop := range hops[1:] {
+		hopConfig, release, err := hop.clientConfig()
 		if err != nil {
-			return nil, fmt.Errorf("创建SOCKS5代理失败: %w", err)
+			closeOwned()
+			return nil, fmt.Errorf("跳板机 %s: %w", hop.Name, err)
 		}
-	default:
-		return nil, fmt.Errorf("不支持的代理类型: %s", p.Type)
+
+		next, err := dialSshVia(via, hop.address(), hopConfig)
+		release()
+		if err != nil {
+			closeOwned()
+			return nil, fmt.Errorf("连接跳板机 %s 失败: %w", hop.Name, err)
+		}
+		owned = append(owned, next)
+		via = next
 	}
 
-	conn, err := dialer.Dial("tcp", sshServerAddr)
+	client, err := dialSshVia(via, addr, config)
 	if err != nil {
-		return nil, fmt.Errorf("通过代理连接失败: %w", err)
+		closeOwned()
+		return nil, err
 	}
 
-	c, chans, reqs, err := ssh.NewClientConn(conn, sshServerAddr, sshConfig)
+	if len(owned) > 0 {
+		go func() {
+			_ = client.Wait()
+			closeOwned()
+		}()
+	}
+
+	return client, nil
+}
+
+// 通过已建立的SSH连接转发到下一跳
+func dialSshVia(via *ssh.Client, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	conn, err := via.Dial("tcp", addr)
 	if err != nil {
-		return nil, fmt.Errorf("创建SSH客户端连接失败: %w", err)
+		return nil, fmt.Errorf("通过跳板机连接 %s 失败: %w", addr, err)
 	}
 
-	return ssh.NewClient(c, chans, reqs), nil
+	return newSshClientConn(conn, addr, config)
 }
 
 // 生成Sftp Client
@@ -346,9 +515,9 @@ func (server *Server) GetSftpClient() (*sftp.Client, error) {
 		return nil, err
 	}
 
+	// sshClient 来自连接池，可能仍被其他会话使用，失败时不能关闭
 	sftpClient, err := sftp.NewClient(sshClient)
 	if err != nil {
-		sshClient.Close()
 		return nil, fmt.Errorf("创建SFTP客户端失败: %w", err)
 	}
 
@@ -362,22 +531,26 @@ func (server *Server) Connect() error {
 
 	client, err := server.GetSshClient()
 	if err != nil {
-		errorType := utils.GetErrorType(err)
-		switch errorType {
-		case "authentication_failed":
-			return errors.New("认证失败，请检查用户名和密码/密钥")
-		case "connection_refused":
-			return errors.New("连接被拒绝，请检查服务器地址和端口")
-		case "timeout":
-			return errors.New("连接超时，请检查网络连接")
-		case "network_error":
-			return errors.New("网络错误，请检查网络连接")
-		default:
-			return fmt.Errorf("SSH连接失败: %w", err)
-		}
+		return connectError(err)
 	}
 	defer client.Close()
 
+	if forwards := server.allForwards(); len(forwards) > 0 {
+		fw := newForwarder(forwards)
+		if err := fw.start(); err != nil {
+			return fmt.Errorf("端口转发失败: %w", err)
+		}
+		defer fw.close()
+
+		if err := fw.setClient(client); err != nil {
+			return fmt.Errorf("端口转发失败: %w", err)
+		}
+
+		for _, f := range forwards {
+			fmt.Printf("🔀 %s\n", f)
+		}
+	}
+
 	fmt.Print("📡 创建SSH会话...\n")
 	session, err := client.NewSession()
 	if err != nil {
@@ -385,6 +558,24 @@ func (server *Server) Connect() error {
 	}
 	defer session.Close()
 
+	if rejected := server.setSessionEnv(session); len(rejected) > 0 {
+		fmt.Printf("⚠️  %s\n", rejectedEnvMessage(rejected))
+	}
+
+	if forwarded, err := server.forwardAgent(client, session); err != nil {
+		fmt.Printf("⚠️  ssh-agent 转发未启用: %v\n", err)
+	} else if forwarded {
+		fmt.Print("🔑 已启用 ssh-agent 转发\n")
+	}
+
+	if server.getForwardX11() {
+		if err := server.forwardX11(client, session); err != nil {
+			fmt.Printf("⚠️  X11 转发未启用: %v\n", err)
+		} else {
+			fmt.Printf("🖥️  已启用 X11 转发 (DISPLAY=%s)\n", os.Getenv("DISPLAY"))
+		}
+	}
+
 	fd := int(os.Stdin.Fd())
 	oldState, err := terminal.MakeRaw(fd)
 	if err != nil {
@@ -392,7 +583,7 @@ func (server *Server) Connect() error {
 	}
 	defer terminal.Restore(fd, oldState)
 
-	stopKeepAliveLoop := server.startKeepAliveLoop(session)
+	stopKeepAliveLoop, dead := server.startKeepAliveLoop(client)
 	defer close(stopKeepAliveLoop)
 
 	err = server.stdIO(session)
@@ -421,20 +612,122 @@ func (server *Server) Connect() error {
 	fmt.Println("✅ SSH连接已建立，正在启动Shell...")
 	fmt.Println()
 
-	err = session.Shell()
+	if command := server.getRemoteCommand(); command != "" {
+		err = session.Start(command)
+	} else {
+		err = session.Shell()
+	}
 	if err != nil {
 		return fmt.Errorf("启动Shell失败: %w", err)
 	}
 
 	_ = session.Wait()
+
+	select {
+	case <-dead:
+		return fmt.Errorf("服务器连续 %d 次未响应心跳，连接已断开", server.getServerAliveCountMax())
+	default:
+	}
 	return nil
 }
 
+// 建立连接失败，保留原始错误以便分类
+type ConnectError struct {
+	Type string // utils.GetErrorType 的分类结果
+	msg  string
+	err  error
+}
+
+func (e *ConnectError) Error() string {
+	return e.msg
+}
+
+func (e *ConnectError) Unwrap() error {
+	return e.err
+}
+
+// 将建立连接时的错误转换为便于理解的提示
+func connectError(err error) error {
+	ce := &ConnectError{Type: utils.GetErrorType(err), err: err}
+	switch ce.Type {
+	case "authentication_failed":
+		ce.msg = "认证失败，请检查用户名和密码/密钥"
+	case "connection_refused":
+		ce.msg = "连接被拒绝，请检查服务器地址和端口"
+	case "timeout":
+		ce.msg = "连接超时，请检查网络连接"
+	case "network_error":
+		ce.msg = "网络错误，请检查网络连接"
+	default:
+		ce.msg = "SSH连接失败: " + err.Error()
+	}
+	return ce
+}
+
+// 错误分类，连接错误使用原始错误的分类
+func errorType(err error) string {
+	var ce *ConnectError
+	if errors.As(err, &ce) {
+		return ce.Type
+	}
+	return utils.GetErrorType(err)
+}
+
+// 配置文件中的转发与命令行指定的转发
+func (server *Server) allForwards() []Forward {
+	forwards := make([]Forward, 0, len(server.Forwards)+len(cliForwards))
+	forwards = append(forwards, server.Forwards...)
+	return append(forwards, cliForwards...)
+}
+
+// 登录后代替Shell执行的命令，在PTY中运行；keep_shell 时结束后进入登录Shell
+func (server *Server) getRemoteCommand() string {
+	command := strings.TrimSpace(server.RemoteCommand)
+	if command == "" || !server.KeepShell {
+		return command
+	}
+	return command + `; exec "$SHELL" -l`
+}
+
+// 交互会话的自动输入：先执行自动应答规则，再在提示符出现后依次输入 startup 命令，
+// 未开启 keep_shell 时最后输入 exit 结束会话
+func (server *Server) interactiveSteps() ([]expectStep, error) {
+	steps, err := newExpectSteps(server.Expect)
+	if err != nil {
+		return nil, err
+	}
+	if len(server.Startup) == 0 {
+		return steps, nil
+	}
+
+	commands := server.Startup
+	if !server.KeepShell {
+		commands = append(commands[:len(commands):len(commands)], "exit")
+	}
+	startup, err := newStartupSteps(server.StartupPrompt, commands)
+	if err != nil {
+		return nil, err
+	}
+	return append(steps, startup...), nil
+}
+
 // 重定向标准输入输出
+// 配置了自动应答规则时，规则执行完毕前不转发用户输入，之后在提示符出现后依次输入 startup 命令
 func (server *Server) stdIO(session *ssh.Session) error {
 	session.Stderr = os.Stderr
 	session.Stdin = os.Stdin
 
+	var stdout io.Writer = os.Stdout
+	steps, err := server.interactiveSteps()
+	if err != nil {
+		return err
+	}
+	if len(steps) > 0 {
+		e := newExpecter(steps, os.Stdin)
+		session.Stdin = e
+		stdout = e.writer(os.Stdout)
+	}
+
 	if server.Log.Enable {
 		ch, err := session.StdoutPipe()
 		if err != nil {
@@ -442,22 +735,14 @@ func (server *Server) stdIO(session *ssh.Session) error {
 		}
 
 		go func() {
-			flag := os.O_RDWR | os.O_CREATE
-			switch server.Log.Mode {
-			case LogModeAppend:
-				flag = flag | os.O_APPEND
-			case LogModeCover:
-			}
-
-			logFile := server.formatLogFilename(server.Log.Filename)
-			f, err := os.OpenFile(logFile, flag, 0644)
+			f, err := server.openLogFile()
 			if err != nil {
-				utils.Logln(fmt.Sprintf("打开日志文件失败: %v", err))
+				utils.Logln(err.Error())
 				return
 			}
 			defer f.Close()
 
-			utils.Logln(fmt.Sprintf("开始记录会话日志到: %s", logFile))
+			utils.Logln(fmt.Sprintf("开始记录会话日志到: %s", f.Name()))
 
 			buff := make([]byte, 4096)
 			for {
@@ -467,7 +752,7 @@ func (server *Server) stdIO(session *ssh.Session) error {
 						utils.Logln(fmt.Sprintf("写入日志文件失败: %v", err))
 					}
 
-					if _, err := os.Stdout.Write(buff[:n]); err != nil {
+					if _, err := stdout.Write(buff[:n]); err != nil {
 						utils.Logln(fmt.Sprintf("写入标准输出失败: %v", err))
 					}
 				}
@@ -477,12 +762,29 @@ func (server *Server) stdIO(session *ssh.Session) error {
 			}
 		}()
 	} else {
-		session.Stdout = os.Stdout
+		session.Stdout = stdout
 	}
 
 	return nil
 }
 
+// 按日志配置打开会话日志文件
+func (server *Server) openLogFile() (*os.File, error) {
+	flag := os.O_RDWR | os.O_CREATE
+	switch server.Log.Mode {
+	case LogModeAppend:
+		flag = flag | os.O_APPEND
+	case LogModeCover:
+	}
+
+	logFile := server.formatLogFilename(server.Log.Filename)
+	f, err := os.OpenFile(logFile, flag, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("打开日志文件失败: %v", err)
+	}
+	return f, nil
+}
+
 // 格式化日志文件名
 func (server *Server) formatLogFilename(filename string) string {
 	kvs := []map[string]string{
@@ -504,32 +806,167 @@ func (server *Server) formatLogFilename(filename string) string {
 }
 
 // 解析鉴权方式
-func parseAuthMethods(server *Server) ([]ssh.AuthMethod, error) {
-	var authMethods []ssh.AuthMethod
+// 按配置顺序生成认证方法，与 OpenSSH 一致：所有公钥类方法（agent、key）合并为一个 publickey
+// 认证，按顺序依次提供各个身份；单个方法初始化失败时跳过，全部失败时才返回错误。
+// release 关闭认证期间打开的 ssh-agent 连接，应在握手结束后调用
+func parseAuthMethods(server *Server) (authMethods []ssh.AuthMethod, release func(), err error) {
+	var signerFuncs []func() ([]ssh.Signer, error)
+	var closers []io.Closer
+	var failures []string
+	var lastErr error
+	release = func() {
+		for _, c := range closers {
+			c.Close()
+		}
+	}
 
-	switch strings.ToLower(server.Method) {
-	case "password":
-		if server.Password == "" {
-			return nil, errors.New("密码认证模式下密码不能为空")
+	names := server.authMethodNames()
+	for _, name := range names {
+		var err error
+		switch name {
+		case "password":
+			if server.Password == "" {
+				err = errors.New("密码认证模式下密码不能为空")
+				break
+			}
+			authMethods = append(authMethods, ssh.Password(server.Password))
+
+		case "key":
+			var signers []ssh.Signer
+			if signers, err = keySigners(server); err != nil {
+				break
+			}
+			if len(signerFuncs) == 0 {
+				authMethods = append(authMethods, ssh.PublicKeysCallback(combineSigners(&signerFuncs)))
+			}
+			signerFuncs = append(signerFuncs, func() ([]ssh.Signer, error) {
+				return signers, nil
+			})
+
+		case "agent":
+			var signers func() ([]ssh.Signer, error)
+			var conn io.Closer
+			if signers, conn, err = sshAgent(); err != nil {
+				break
+			}
+			closers = append(closers, conn)
+			if len(signerFuncs) == 0 {
+				authMethods = append(authMethods, ssh.PublicKeysCallback(combineSigners(&signerFuncs)))
+			}
+			signerFuncs = append(signerFuncs, signers)
+
+		case "keyboard-interactive":
+			authMethods = append(authMethods, ssh.KeyboardInteractive(keyboardInteractive(server)))
+
+		default:
+			release()
+			return nil, nil, fmt.Errorf("不支持的认证方法: %s", name)
 		}
-		authMethods = append(authMethods, ssh.Password(server.Password))
 
-	case "key":
-		method, err := pemKey(server)
 		if err != nil {
-			return nil, err
+			lastErr = err
+			failures = append(failures, fmt.Sprintf("%s: %v", name, err))
+			if debug {
+				utils.Debugf("跳过认证方法 %s: %v", name, err)
+			}
 		}
-		authMethods = append(authMethods, method)
+	}
 
-	default:
-		return nil, fmt.Errorf("不支持的认证方法: %s", server.Method)
+	if len(authMethods) == 0 {
+		release()
+		if len(names) == 1 {
+			return nil, nil, lastErr
+		}
+		return nil, nil, fmt.Errorf("没有可用的认证方法: %s", strings.Join(failures, "; "))
 	}
 
-	return authMethods, nil
+	return authMethods, release, nil
+}
+
+// 按顺序合并多个公钥来源的身份
+func combineSigners(signerFuncs *[]func() ([]ssh.Signer, error)) func() ([]ssh.Signer, error) {
+	return func() ([]ssh.Signer, error) {
+		var signers []ssh.Signer
+		for _, fn := range *signerFuncs {
+			s, err := fn()
+			if err != nil {
+				if debug {
+					utils.Debugf("获取身份失败: %v", err)
+				}
+				continue
+			}
+			signers = append(signers, s...)
+		}
+		return signers, nil
+	}
+}
+
+// 解析密钥及其证书
+// 存在证书时优先提供证书身份，其次是原始密钥，与 OpenSSH 的行为一致
+func keySigners(server *Server) ([]ssh.Signer, error) {
+	signer, err := pemKey(server)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := loadCertificate(server)
+	if err != nil {
+		return nil, err
+	}
+	if cert == nil {
+		return []ssh.Signer{signer}, nil
+	}
+
+	certSigner, err := ssh.NewCertSigner(cert, signer)
+	if err != nil {
+		return nil, fmt.Errorf("证书与密钥不匹配: %w", err)
+	}
+
+	return []ssh.Signer{certSigner, signer}, nil
+}
+
+// 加载用户证书
+// 优先使用 cert 字段，否则查找密钥旁边的 <key>-cert.pub，均不存在时返回 nil
+func loadCertificate(server *Server) (*ssh.Certificate, error) {
+	certFile := server.Cert
+	if certFile != "" {
+		certFile, _ = utils.ParsePath(certFile)
+	} else {
+		certFile = server.Key + "-cert.pub"
+		if _, err := os.Stat(certFile); err != nil {
+			return nil, nil
+		}
+	}
+
+	certBytes, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return nil, fmt.Errorf("读取证书文件失败: %w", err)
+	}
+
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(certBytes)
+	if err != nil {
+		return nil, fmt.Errorf("解析证书失败: %w", err)
+	}
+
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("%s 不是 OpenSSH 证书", certFile)
+	}
+
+	now := time.Now().Unix()
+	if now < int64(cert.ValidAfter) {
+		return nil, fmt.Errorf("证书尚未生效: %s（生效时间 %s）", certFile, time.Unix(int64(cert.ValidAfter), 0).Format("2006-01-02 15:04:05"))
+	}
+	if cert.ValidBefore != ssh.CertTimeInfinity && now >= int64(cert.ValidBefore) {
+		return nil, fmt.Errorf("证书已过期: %s", certFile)
+	}
+
+	return cert, nil
 }
 
 // 解析密钥
-func pemKey(server *Server) (ssh.AuthMethod, error) {
+// 未加密的密钥直接解析，加密的密钥使用 password 作为口令
+func pemKey(server *Server) (ssh.Signer, error) {
 	if server.Key == "" {
 		server.Key = "~/.ssh/id_rsa"
 	}
@@ -540,10 +977,12 @@ func pemKey(server *Server) (ssh.AuthMethod, error) {
 		return nil, fmt.Errorf("读取密钥文件失败: %w", err)
 	}
 
-	var signer ssh.Signer
-	if server.Password == "" {
-		signer, err = ssh.ParsePrivateKey(pemBytes)
-	} else {
+	signer, err := ssh.ParsePrivateKey(pemBytes)
+	var missingErr *ssh.PassphraseMissingError
+	if errors.As(err, &missingErr) {
+		if server.Password == "" {
+			return nil, errors.New("密钥已加密，但未配置口令")
+		}
 		signer, err = ssh.ParsePrivateKeyWithPassphrase(pemBytes, []byte(server.Password))
 	}
 
@@ -551,36 +990,158 @@ func pemKey(server *Server) (ssh.AuthMethod, error) {
 		return nil, fmt.Errorf("解析密钥失败: %w", err)
 	}
 
-	return ssh.PublicKeys(signer), nil
+	return signer, nil
+}
+
+// 通过 ssh-agent 认证，使用 agent 中的全部身份
+// 签名在握手期间进行，返回的连接需在握手结束后关闭
+func sshAgent() (func() ([]ssh.Signer, error), io.Closer, error) {
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return nil, nil, errors.New("未检测到 ssh-agent（SSH_AUTH_SOCK 为空）")
+	}
+
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, nil, fmt.Errorf("连接 ssh-agent 失败: %w", err)
+	}
+
+	return agent.NewClient(conn).Signers, conn, nil
+}
+
+// 键盘交互认证
+// 密码问题使用 password 应答，动态码问题根据 totp_secret 生成 TOTP，其余问题在终端中提示用户输入
+func keyboardInteractive(server *Server) ssh.KeyboardInteractiveChallenge {
+	return func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+		answers := make([]string, len(questions))
+		locked := false
+		defer func() {
+			if locked {
+				promptMutex.Unlock()
+			}
+		}()
+		for i, question := range questions {
+			prompt := strings.ToLower(question)
+			switch {
+			case server.TotpSecret != "" && isOtpPrompt(prompt):
+				code, err := utils.GenerateTOTP(server.TotpSecret, time.Now())
+				if err != nil {
+					return nil, fmt.Errorf("生成动态码失败: %w", err)
+				}
+				answers[i] = code
+
+			case server.Password != "" && isPasswordPrompt(prompt):
+				answers[i] = server.Password
+
+			default:
+				// 并行连接多台服务器时，一组问题回答完之前不显示其他服务器的提示
+				if !locked {
+					promptMutex.Lock()
+					locked = true
+				}
+				answer, err := promptTerminal(instruction, question, echos[i])
+				if err != nil {
+					return nil, err
+				}
+				answers[i] = answer
+				instruction = ""
+			}
+		}
+		return answers, nil
+	}
+}
+
+func isPasswordPrompt(prompt string) bool {
+	return strings.Contains(prompt, "password") || strings.Contains(prompt, "密码")
+}
+
+func isOtpPrompt(prompt string) bool {
+	for _, word := range []string{"verification code", "one-time", "otp", "token", "authenticator", "2fa", "验证码", "动态码", "动态口令"} {
+		if strings.Contains(prompt, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// 在终端中提示用户回答认证问题，提示写到标准错误
+func promptTerminal(instruction, question string, echo bool) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(fd) {
+		return "", fmt.Errorf("无法在非交互终端中回答认证问题: %s", strings.TrimSpace(question))
+	}
+
+	if instruction != "" {
+		fmt.Fprintln(os.Stderr, instruction)
+	}
+	fmt.Fprint(os.Stderr, question)
+
+	if echo {
+		var answer string
+		utils.Scanln(&answer)
+		return answer, nil
+	}
+
+	answer, err := terminal.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("读取输入失败: %w", err)
+	}
+	return string(answer), nil
 }
 
 // 发送心跳包
-func (server *Server) startKeepAliveLoop(session *ssh.Session) chan struct{} {
-	terminate := make(chan struct{})
+// 每隔 ServerAliveInterval 秒发送一次，连续 ServerAliveCountMax 次未收到响应时关闭连接，
+// dead 在因心跳超时关闭连接时被关闭
+func (server *Server) startKeepAliveLoop(client *ssh.Client) (terminate chan struct{}, dead chan struct{}) {
+	terminate = make(chan struct{})
+	dead = make(chan struct{})
+
+	interval := server.getServerAliveInterval()
+	if interval <= 0 {
+		return terminate, dead
+	}
+	countMax := server.getServerAliveCountMax()
+
 	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		// 同一时间只发送一个心跳请求，等待响应期间每经过一个周期计为一次未响应
+		var reply chan error
+		missed := 0
 		for {
 			select {
 			case <-terminate:
 				return
-			default:
-				if val, ok := server.Options["ServerAliveInterval"]; ok && val != nil {
-					_, err := session.SendRequest("keepalive@bbr", true, nil)
-					if err != nil {
-						utils.Errorf("发送心跳包失败: %v", err)
-					}
+			case err := <-reply:
+				reply = nil
+				if err != nil {
+					// 连接已关闭
+					return
+				}
+				missed = 0
+			case <-ticker.C:
+				if reply == nil {
+					reply = make(chan error, 1)
+					go func(reply chan<- error) {
+						_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+						reply <- err
+					}(reply)
+					continue
+				}
 
-					if interval, ok := val.(float64); ok {
-						time.Sleep(time.Duration(interval) * time.Second)
-					} else {
-						time.Sleep(60 * time.Second) // 默认60秒
-					}
-				} else {
+				missed++
+				if missed >= countMax {
+					close(dead)
+					client.Close()
 					return
 				}
 			}
 		}
 	}()
-	return terminate
+
+	return terminate, dead
 }
 
 // 监听终端窗口变化
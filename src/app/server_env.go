@@ -0,0 +1,112 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// 环境变量相关选项的来源：全局选项在前，服务器选项在后
+// 服务器选项已合并了全局选项中未设置的项，这里需要分别读取才能累加
+func (server *Server) envOptionSources() []map[string]interface{} {
+	var sources []map[string]interface{}
+	if server.cfg != nil && server.cfg.Options != nil {
+		sources = append(sources, server.cfg.Options)
+	}
+	return append(sources, server.Options)
+}
+
+// SendEnv 选项中的变量名模式，支持数组或以空格分隔的字符串，模式可使用 * 与 ?
+// 与 OpenSSH 一样，全局与服务器中的 SendEnv 累加
+func (server *Server) getSendEnv() []string {
+	var patterns []string
+	seen := make(map[string]bool)
+	add := func(pattern string) {
+		if !seen[pattern] {
+			seen[pattern] = true
+			patterns = append(patterns, pattern)
+		}
+	}
+
+	for _, options := range server.envOptionSources() {
+		switch val := options["SendEnv"].(type) {
+		case string:
+			for _, pattern := range strings.Fields(val) {
+				add(pattern)
+			}
+		case []interface{}:
+			for _, v := range val {
+				if s, ok := v.(string); ok {
+					add(s)
+				}
+			}
+		}
+	}
+	return patterns
+}
+
+// SetEnv 选项，变量名到值的映射；全局与服务器中的 SetEnv 按变量合并，服务器中的优先
+func (server *Server) getSetEnv() map[string]string {
+	env := make(map[string]string)
+	for _, options := range server.envOptionSources() {
+		if val, ok := options["SetEnv"].(map[string]interface{}); ok {
+			for name, v := range val {
+				env[name] = fmt.Sprint(v)
+			}
+		}
+	}
+	return env
+}
+
+// 需要发送到服务器的环境变量：SendEnv 匹配的本地变量，SetEnv 中的同名变量优先
+func (server *Server) sessionEnv() map[string]string {
+	env := make(map[string]string)
+
+	patterns := server.getSendEnv()
+	if len(patterns) > 0 {
+		for _, kv := range os.Environ() {
+			name, value, ok := strings.Cut(kv, "=")
+			if !ok {
+				continue
+			}
+			for _, pattern := range patterns {
+				if matched, _ := path.Match(pattern, name); matched {
+					env[name] = value
+					break
+				}
+			}
+		}
+	}
+
+	for name, value := range server.getSetEnv() {
+		env[name] = value
+	}
+	return env
+}
+
+// 在会话启动前设置环境变量，返回被服务器拒绝的变量名
+// 服务器只接受 sshd_config 中 AcceptEnv 允许的变量
+func (server *Server) setSessionEnv(session *ssh.Session) []string {
+	env := server.sessionEnv()
+	names := make([]string, 0, len(env))
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var rejected []string
+	for _, name := range names {
+		if err := session.Setenv(name, env[name]); err != nil {
+			rejected = append(rejected, name)
+		}
+	}
+	return rejected
+}
+
+func rejectedEnvMessage(rejected []string) string {
+	return fmt.Sprintf("服务器拒绝了环境变量 %s（请检查服务器 sshd_config 中的 AcceptEnv）", strings.Join(rejected, ", "))
+}
@@ -0,0 +1,328 @@
+package app
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// 无法建立连接或远程未返回退出状态时使用的退出码，与 OpenSSH 一致
+const ExitCodeConnectFailed = 255
+
+// 非交互执行远程命令的参数
+type ExecOptions struct {
+	Command string
+	Tty     bool      // 分配PTY，交互式程序（如 top、sudo 提示）需要
+	Sudo    bool      // 以 sudo 执行，自动输入 sudo_password
+	Stdin   io.Reader // 为空时不转发标准输入
+	Stdout  io.Writer
+	Stderr  io.Writer
+
+	Interrupt <-chan struct{} // 关闭时中断远程命令，Exec 返回 130
+}
+
+// 在服务器上执行命令，标准输出与标准错误分别写入，返回远程命令的退出码
+func (server *Server) Exec(opts ExecOptions) (int, error) {
+	client, err := server.GetSshClient()
+	if err != nil {
+		return ExitCodeConnectFailed, connectError(err)
+	}
+
+	session, err := client.NewSession()
+	if err != nil {
+		return ExitCodeConnectFailed, fmt.Errorf("创建SSH会话失败: %w", err)
+	}
+	defer session.Close()
+
+	if rejected := server.setSessionEnv(session); len(rejected) > 0 {
+		fmt.Fprintf(os.Stderr, "⚠️  %s: %s\n", server.Name, rejectedEnvMessage(rejected))
+	}
+
+	stdout := opts.Stdout
+	if stdout == nil {
+		stdout = io.Discard
+	}
+	if server.Log.Enable {
+		f, err := server.openLogFile()
+		if err != nil {
+			return ExitCodeConnectFailed, err
+		}
+		defer f.Close()
+		stdout = io.MultiWriter(stdout, f)
+	}
+	stderr := opts.Stderr
+	if stderr == nil {
+		stderr = io.Discard
+	}
+
+	// PTY 的原始模式与窗口大小取决于本地标准输入，需在 sudo 包装之前保留
+	localStdin := opts.Stdin
+	var sudo *sudoResponder
+	if opts.Sudo {
+		if sudo, err = newSudoResponder(server.sudoPassword(), opts.Tty, opts.Stdin); err != nil {
+			return ExitCodeConnectFailed, err
+		}
+		opts.Command = sudo.wrap(opts.Command)
+		opts.Stdin = sudo
+		if opts.Tty {
+			stdout = sudo.writer(stdout)
+		} else {
+			stderr = sudo.writer(stderr)
+		}
+	}
+
+	session.Stdout = stdout
+	session.Stderr = stderr
+
+	if opts.Tty {
+		restore, err := server.requestExecPty(session, localStdin)
+		if err != nil {
+			return ExitCodeConnectFailed, err
+		}
+		defer restore()
+	}
+
+	// 不使用 session.Stdin：会话结束时不必等待本地输入读完
+	if opts.Stdin != nil {
+		stdin, err := session.StdinPipe()
+		if err != nil {
+			return ExitCodeConnectFailed, fmt.Errorf("获取标准输入管道失败: %w", err)
+		}
+		go func() {
+			_, _ = io.Copy(stdin, opts.Stdin)
+			stdin.Close()
+		}()
+	}
+
+	stopKeepAliveLoop, dead := server.startKeepAliveLoop(client)
+	defer close(stopKeepAliveLoop)
+
+	select {
+	case <-opts.Interrupt:
+		return 130, errors.New("命令已中断")
+	default:
+	}
+	if err := session.Start(opts.Command); err != nil {
+		return ExitCodeConnectFailed, fmt.Errorf("执行命令失败: %w", err)
+	}
+
+	// 中断时通知远程进程并关闭会话，由调用方完成清理，而不是直接退出进程
+	finished := make(chan struct{})
+	interrupted := make(chan struct{})
+	go func() {
+		select {
+		case <-opts.Interrupt:
+			close(interrupted)
+			_ = session.Signal(ssh.SIGINT)
+			_ = session.Close()
+		case <-finished:
+		}
+	}()
+
+	err = session.Wait()
+	close(finished)
+	select {
+	case <-interrupted:
+		return 130, errors.New("命令已中断")
+	default:
+	}
+	if sudo != nil {
+		if sudoErr := sudo.finish(); sudoErr != nil && err != nil {
+			var exitErr *ssh.ExitError
+			if errors.As(err, &exitErr) {
+				return exitErr.ExitStatus(), sudoErr
+			}
+		}
+	}
+
+	select {
+	case <-dead:
+		return ExitCodeConnectFailed, fmt.Errorf("服务器连续 %d 次未响应心跳，连接已断开", server.getServerAliveCountMax())
+	default:
+	}
+
+	if err == nil {
+		return 0, nil
+	}
+
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitStatus(), nil
+	}
+	var missingErr *ssh.ExitMissingError
+	if errors.As(err, &missingErr) {
+		return ExitCodeConnectFailed, errors.New("远程命令未返回退出状态，连接可能已中断")
+	}
+	return ExitCodeConnectFailed, fmt.Errorf("执行命令失败: %w", err)
+}
+
+// 为命令请求PTY，本地标准输入是终端时切换到原始模式并同步窗口大小
+func (server *Server) requestExecPty(session *ssh.Session, stdin io.Reader) (restore func(), err error) {
+	restore = func() {}
+	width, height := 80, 24
+
+	termType := os.Getenv("TERM")
+	if termType == "" {
+		termType = "xterm-256color"
+	}
+
+	if f, ok := stdin.(*os.File); ok && terminal.IsTerminal(int(f.Fd())) {
+		fd := int(f.Fd())
+		if w, h, err := terminal.GetSize(fd); err == nil {
+			width, height = w, h
+		}
+
+		oldState, err := terminal.MakeRaw(fd)
+		if err != nil {
+			return restore, fmt.Errorf("设置终端原始模式失败: %w", err)
+		}
+		restore = func() { _ = terminal.Restore(fd, oldState) }
+		server.listenWindowChange(session, fd)
+	}
+
+	modes := ssh.TerminalModes{
+		ssh.ECHO:          1,
+		ssh.TTY_OP_ISPEED: 14400,
+		ssh.TTY_OP_OSPEED: 14400,
+	}
+	if err := session.RequestPty(termType, height, width, modes); err != nil {
+		restore()
+		return func() {}, fmt.Errorf("请求PTY失败: %w", err)
+	}
+	return restore, nil
+}
+
+// 上传本地脚本到远程临时文件执行，结束后删除
+// stream 为真、服务器不支持SFTP或上传失败时，改为通过标准输入交给解释器执行（此时不再转发本地标准输入）
+func (server *Server) RunScript(script string, args []string, stream bool, opts ExecOptions) (int, error) {
+	content, err := os.ReadFile(script)
+	if err != nil {
+		return ExitCodeConnectFailed, fmt.Errorf("读取脚本失败: %w", err)
+	}
+
+	if _, err := server.GetSshClient(); err != nil {
+		return ExitCodeConnectFailed, connectError(err)
+	}
+
+	// 中断时结束远程命令并正常返回，使临时文件删除、终端恢复等清理得以执行
+	interrupt, stop := notifyInterrupt()
+	defer stop()
+	opts.Interrupt = interrupt
+
+	if !stream {
+		sftpClient, err := server.GetSftpClient()
+		if err == nil {
+			defer sftpClient.Close()
+			var remotePath string
+			if remotePath, err = uploadScript(sftpClient, filepath.Base(script), content); err == nil {
+				defer func() { _ = sftpClient.Remove(remotePath) }()
+				opts.Command = shellQuote(remotePath) + " " + shellJoin(args)
+				return server.Exec(opts)
+			}
+		}
+		fmt.Fprintf(os.Stderr, "⚠️  %v，改为通过标准输入执行脚本\n", err)
+	}
+
+	if opts.Tty {
+		return ExitCodeConnectFailed, errors.New("通过标准输入执行脚本时不支持分配PTY")
+	}
+	opts.Command = scriptInterpreter(content) + " " + shellJoin(args)
+	opts.Stdin = bytes.NewReader(content)
+	return server.Exec(opts)
+}
+
+// 收到 SIGINT 或 SIGTERM 时关闭返回的通道，stop 停止监听
+func notifyInterrupt() (<-chan struct{}, func()) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+
+	interrupt := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-sigCh:
+			close(interrupt)
+		case <-done:
+		}
+	}()
+	return interrupt, func() {
+		signal.Stop(sigCh)
+		close(done)
+	}
+}
+
+// 上传脚本到远程临时文件并设置可执行权限，返回远程路径
+// 优先使用登录目录（/tmp 常以 noexec 挂载），不可写时再尝试 /tmp
+func uploadScript(sftpClient *sftp.Client, name string, content []byte) (string, error) {
+	suffix := make([]byte, 6)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	name = "autossh-" + hex.EncodeToString(suffix) + "-" + name
+
+	var candidates []string
+	if home, err := sftpClient.Getwd(); err == nil && home != "" {
+		candidates = append(candidates, path.Join(home, "."+name))
+	}
+	candidates = append(candidates, path.Join("/tmp", name))
+
+	var err error
+	for _, remotePath := range candidates {
+		var f *sftp.File
+		if f, err = sftpClient.OpenFile(remotePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL); err != nil {
+			continue
+		}
+		_, err = f.Write(content)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = sftpClient.Chmod(remotePath, 0700)
+		}
+		if err == nil {
+			return remotePath, nil
+		}
+		_ = sftpClient.Remove(remotePath)
+	}
+	return "", fmt.Errorf("上传脚本失败: %w", err)
+}
+
+// 根据 shebang 选择从标准输入读取脚本的解释器，没有 shebang 时使用 sh
+func scriptInterpreter(content []byte) string {
+	if bytes.HasPrefix(content, []byte("#!")) {
+		line := string(content[2:])
+		if i := strings.IndexByte(line, '\n'); i >= 0 {
+			line = line[:i]
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			return line + " /dev/stdin"
+		}
+	}
+	return "sh -s --"
+}
+
+// 单引号转义，供远程 shell 原样接收参数
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func shellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = shellQuote(arg)
+	}
+	return strings.Join(quoted, " ")
+}
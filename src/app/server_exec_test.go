@@ -0,0 +1,776 @@
+package app
+
+import (
+	"autossh/src/utils"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// 启动一个在本地以 sh -c 执行命令的SSH服务器，返回可连接的服务器配置
+func startTestSSHServer(t *testing.T) *Server {
+	t.Helper()
+	return startTestSSHServerWithKeys(t)
+}
+
+// 启动测试服务器，除密码外还接受 authorized 中的公钥
+func startTestSSHServerWithKeys(t *testing.T, authorized ...ssh.PublicKey) *Server {
+	t.Helper()
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKey, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if string(password) != "secret" {
+				return nil, errors.New("wrong password")
+			}
+			return nil, nil
+		},
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			for _, k := range authorized {
+				if bytes.Equal(k.Marshal(), key.Marshal()) {
+					return nil, nil
+				}
+			}
+			return nil, errors.New("unknown public key")
+		},
+		// 依次询问密码与动态码，用户名为 prompt 时再追加一个无法自动回答的问题
+		KeyboardInteractiveCallback: func(conn ssh.ConnMetadata, challenge ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+			questions := []string{"Password: ", "Verification code: "}
+			if conn.User() == "prompt" {
+				questions = append(questions, "Favorite color: ")
+			}
+			answers, err := challenge(conn.User(), "", questions, make([]bool, len(questions)))
+			if err != nil {
+				return nil, err
+			}
+			if len(answers) != len(questions) || answers[0] != "secret" || !validTestTOTP(answers[1]) {
+				return nil, errors.New("wrong answers")
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveTestSSHConn(conn, config)
+		}
+	}()
+
+	addr := ln.Addr().(*net.TCPAddr)
+	return &Server{
+		Name:     "test",
+		Ip:       "127.0.0.1",
+		Port:     addr.Port,
+		User:     "tester",
+		Password: "secret",
+		Method:   "password",
+		Alias:    "test" + strconv.Itoa(addr.Port),
+		Options:  map[string]interface{}{"StrictHostKeyChecking": HostKeyCheckOff},
+	}
+}
+
+// 测试服务器使用的 TOTP 密钥
+const testTotpSecret = "JBSWY3DPEHPK3PXP"
+
+// 动态码在当前或上一个时间窗口内有效，避免恰好跨越窗口时失败
+func validTestTOTP(code string) bool {
+	now := time.Now()
+	for _, t := range []time.Time{now, now.Add(-30 * time.Second)} {
+		if want, err := utils.GenerateTOTP(testTotpSecret, t); err == nil && want == code {
+			return true
+		}
+	}
+	return false
+}
+
+func serveTestSSHConn(conn net.Conn, config *ssh.ServerConfig) {
+	sconn, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go serveTestGlobalRequests(sconn, reqs)
+
+	for newChannel := range chans {
+		switch newChannel.ChannelType() {
+		case "session":
+			channel, requests, err := newChannel.Accept()
+			if err != nil {
+				continue
+			}
+			go serveTestSession(sconn, channel, requests)
+		case "direct-tcpip":
+			var payload struct {
+				Host     string
+				Port     uint32
+				OrigHost string
+				OrigPort uint32
+			}
+			_ = ssh.Unmarshal(newChannel.ExtraData(), &payload)
+			go serveTestDirect(newChannel, "tcp", net.JoinHostPort(payload.Host, strconv.Itoa(int(payload.Port))))
+		case "direct-streamlocal@openssh.com":
+			var payload struct {
+				SocketPath string
+				Reserved0  string
+				Reserved1  uint32
+			}
+			_ = ssh.Unmarshal(newChannel.ExtraData(), &payload)
+			go serveTestDirect(newChannel, "unix", payload.SocketPath)
+		default:
+			_ = newChannel.Reject(ssh.UnknownChannelType, "unsupported")
+		}
+	}
+}
+
+// 远程转发：支持 tcpip-forward 与 streamlocal-forward@openssh.com，
+// 与非 root 运行的 sshd 一样拒绝监听 1024 以下的端口；连接断开后关闭所有监听
+func serveTestGlobalRequests(sconn *ssh.ServerConn, reqs <-chan *ssh.Request) {
+	listeners := make(map[string]net.Listener)
+	defer func() {
+		for _, ln := range listeners {
+			ln.Close()
+		}
+	}()
+
+	for req := range reqs {
+		switch req.Type {
+		case "tcpip-forward", "cancel-tcpip-forward":
+			var payload struct {
+				Addr string
+				Port uint32
+			}
+			_ = ssh.Unmarshal(req.Payload, &payload)
+			addr := net.JoinHostPort(payload.Addr, strconv.Itoa(int(payload.Port)))
+			if req.Type == "cancel-tcpip-forward" {
+				if ln, ok := listeners[addr]; ok {
+					ln.Close()
+					delete(listeners, addr)
+				}
+				_ = req.Reply(true, nil)
+				continue
+			}
+			if payload.Port != 0 && payload.Port < 1024 {
+				_ = req.Reply(false, nil)
+				continue
+			}
+			ln, err := net.Listen("tcp", addr)
+			if err != nil {
+				_ = req.Reply(false, nil)
+				continue
+			}
+			port := uint32(ln.Addr().(*net.TCPAddr).Port)
+			listeners[net.JoinHostPort(payload.Addr, strconv.Itoa(int(port)))] = ln
+			_ = req.Reply(true, ssh.Marshal(struct{ Port uint32 }{port}))
+
+			go acceptTestForwarded(sconn, ln, "forwarded-tcpip", func(conn net.Conn) []byte {
+				origin := conn.RemoteAddr().(*net.TCPAddr)
+				return ssh.Marshal(struct {
+					Addr       string
+					Port       uint32
+					OriginAddr string
+					OriginPort uint32
+				}{payload.Addr, port, origin.IP.String(), uint32(origin.Port)})
+			})
+		case "streamlocal-forward@openssh.com", "cancel-streamlocal-forward@openssh.com":
+			var payload struct{ SocketPath string }
+			_ = ssh.Unmarshal(req.Payload, &payload)
+			if req.Type == "cancel-streamlocal-forward@openssh.com" {
+				if ln, ok := listeners[payload.SocketPath]; ok {
+					ln.Close()
+					delete(listeners, payload.SocketPath)
+				}
+				_ = req.Reply(true, nil)
+				continue
+			}
+			ln, err := net.Listen("unix", payload.SocketPath)
+			if err != nil {
+				_ = req.Reply(false, nil)
+				continue
+			}
+			listeners[payload.SocketPath] = ln
+			_ = req.Reply(true, nil)
+
+			go acceptTestForwarded(sconn, ln, "forwarded-streamlocal@openssh.com", func(conn net.Conn) []byte {
+				return ssh.Marshal(struct {
+					SocketPath string
+					Reserved   string
+				}{payload.SocketPath, ""})
+			})
+		default:
+			_ = req.Reply(false, nil)
+		}
+	}
+}
+
+// 将服务器上监听到的连接经新打开的通道转发给客户端
+func acceptTestForwarded(sconn *ssh.ServerConn, ln net.Listener, channelType string, extraData func(conn net.Conn) []byte) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			channel, reqs, err := sconn.OpenChannel(channelType, extraData(conn))
+			if err != nil {
+				conn.Close()
+				return
+			}
+			go ssh.DiscardRequests(reqs)
+			pipeConn(channel, conn)
+		}()
+	}
+}
+
+// 本地转发：服务器连接目标地址后双向转发
+func serveTestDirect(newChannel ssh.NewChannel, network, addr string) {
+	target, err := net.Dial(network, addr)
+	if err != nil {
+		_ = newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	channel, reqs, err := newChannel.Accept()
+	if err != nil {
+		target.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	pipeConn(channel, target)
+}
+
+// 支持 env、pty-req、auth-agent-req、x11-req、sftp 与 exec 请求；只接受 LANG、LC_* 与 DEPLOY_ENV 环境变量。
+// 请求了PTY时与真实终端一样，标准错误合并到标准输出
+func serveTestSession(sconn *ssh.ServerConn, channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer channel.Close()
+
+	var env []string
+	tty := false
+	for req := range requests {
+		switch req.Type {
+		case "env":
+			var kv struct{ Name, Value string }
+			_ = ssh.Unmarshal(req.Payload, &kv)
+			ok := kv.Name == "LANG" || kv.Name == "DEPLOY_ENV" || strings.HasPrefix(kv.Name, "LC_")
+			if ok {
+				env = append(env, kv.Name+"="+kv.Value)
+			}
+			_ = req.Reply(ok, nil)
+		case "pty-req":
+			tty = true
+			_ = req.Reply(true, nil)
+		case "auth-agent-req@openssh.com":
+			_ = req.Reply(true, nil)
+		case "x11-req":
+			var payload struct {
+				SingleConnection bool
+				AuthProtocol     string
+				AuthCookie       string
+				ScreenNumber     uint32
+			}
+			_ = ssh.Unmarshal(req.Payload, &payload)
+			_ = req.Reply(true, nil)
+			cookie, _ := hex.DecodeString(payload.AuthCookie)
+			go openTestX11Channel(sconn, payload.AuthProtocol, cookie)
+		case "subsystem":
+			var payload struct{ Name string }
+			_ = ssh.Unmarshal(req.Payload, &payload)
+			if payload.Name != "sftp" {
+				_ = req.Reply(false, nil)
+				continue
+			}
+			_ = req.Reply(true, nil)
+			if server, err := sftp.NewServer(channel); err == nil {
+				_ = server.Serve()
+			}
+			return
+		case "exec":
+			var payload struct{ Command string }
+			_ = ssh.Unmarshal(req.Payload, &payload)
+			_ = req.Reply(true, nil)
+
+			cmd := exec.Command("sh", "-c", payload.Command)
+			cmd.Env = env
+			cmd.Stdin = channel
+			cmd.Stdout = channel
+			cmd.Stderr = channel.Stderr()
+			if tty {
+				cmd.Stderr = channel
+			}
+
+			status := 0
+			if err := cmd.Run(); err != nil {
+				status = 1
+				var exitErr *exec.ExitError
+				if errors.As(err, &exitErr) {
+					status = exitErr.ExitCode()
+				}
+			}
+
+			code := make([]byte, 4)
+			binary.BigEndian.PutUint32(code, uint32(status))
+			_, _ = channel.SendRequest("exit-status", false, code)
+			return
+		default:
+			_ = req.Reply(false, nil)
+		}
+	}
+}
+
+// 模拟远程X11客户端：打开 x11 通道并以会话收到的 cookie 发起连接
+func openTestX11Channel(sconn *ssh.ServerConn, protocol string, cookie []byte) {
+	origin := struct {
+		Address string
+		Port    uint32
+	}{"127.0.0.1", 0}
+	channel, reqs, err := sconn.OpenChannel("x11", ssh.Marshal(&origin))
+	if err != nil {
+		return
+	}
+	defer channel.Close()
+	go ssh.DiscardRequests(reqs)
+
+	if _, err := channel.Write(x11Setup(protocol, cookie)); err != nil {
+		return
+	}
+	_, _ = io.Copy(io.Discard, channel)
+}
+
+func TestServer_Exec(t *testing.T) {
+	server := startTestSSHServer(t)
+
+	var stdout, stderr bytes.Buffer
+	code, err := server.Exec(ExecOptions{
+		Command: "cat; echo oops >&2; exit 3",
+		Stdin:   strings.NewReader("hello\n"),
+		Stdout:  &stdout,
+		Stderr:  &stderr,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != 3 {
+		t.Errorf("exit code = %d, want 3", code)
+	}
+	if stdout.String() != "hello\n" || stderr.String() != "oops\n" {
+		t.Errorf("stdout = %q, stderr = %q", stdout.String(), stderr.String())
+	}
+}
+
+// 并发获取同一服务器的连接时只建立一个连接
+func TestServer_GetSshClientConcurrent(t *testing.T) {
+	server := startTestSSHServer(t)
+
+	clients := make([]*ssh.Client, 8)
+	var wg sync.WaitGroup
+	for i := range clients {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			client, err := server.GetSshClient()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			clients[i] = client
+		}(i)
+	}
+	wg.Wait()
+
+	for _, client := range clients[1:] {
+		if client != clients[0] {
+			t.Fatal("concurrent callers got different clients")
+		}
+	}
+}
+
+// 启动一个只包含新生成密钥的 ssh-agent 并设置 SSH_AUTH_SOCK，
+// 返回其公钥与当前打开的 agent 连接数
+func startTestAgent(t *testing.T) (ssh.PublicKey, func() int) {
+	t.Helper()
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(agent.AddedKey{PrivateKey: priv}); err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	t.Setenv("SSH_AUTH_SOCK", socket)
+
+	var mu sync.Mutex
+	open := 0
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			open++
+			mu.Unlock()
+			go func() {
+				_ = agent.ServeAgent(keyring, conn)
+				conn.Close()
+				mu.Lock()
+				open--
+				mu.Unlock()
+			}()
+		}
+	}()
+
+	return signer.PublicKey(), func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return open
+	}
+}
+
+// 连接池复用的连接上再次打开会话时，agent 转发仍然可用
+func TestServer_forwardAgentReusedClient(t *testing.T) {
+	startTestAgent(t)
+	server := startTestSSHServer(t)
+	server.Options["ForwardAgent"] = true
+
+	for i := 0; i < 2; i++ {
+		client, err := server.GetSshClient()
+		if err != nil {
+			t.Fatal(err)
+		}
+		session, err := client.NewSession()
+		if err != nil {
+			t.Fatal(err)
+		}
+		forwarded, err := server.forwardAgent(client, session)
+		session.Close()
+		if err != nil || !forwarded {
+			t.Fatalf("session %d: forwarded=%v err=%v", i, forwarded, err)
+		}
+	}
+}
+
+func TestParseExecArgs(t *testing.T) {
+	ea, err := parseExecArgs([]string{"-t", "web01", "--", "ls", "-l"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ea.alias != "web01" || ea.command != "ls -l" || !ea.tty {
+		t.Errorf("got %+v", ea)
+	}
+
+	ea, err = parseExecArgs([]string{"web01", "-n", "--", "uptime"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ea.alias != "web01" || ea.command != "uptime" || !ea.noStdin {
+		t.Errorf("got %+v", ea)
+	}
+
+	for _, args := range [][]string{{"web01"}, {"--", "uptime"}} {
+		if _, err := parseExecArgs(args); err == nil {
+			t.Errorf("%v: expected error", args)
+		}
+	}
+}
+
+// 写入一个只有 web01 的配置文件，web01 指向一个已关闭的端口
+func writeExecTestConfig(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	file := filepath.Join(t.TempDir(), "config.json")
+	content := `{"servers": [{"name": "a", "ip": "127.0.0.1", "port": ` + strconv.Itoa(port) +
+		`, "user": "root", "password": "x", "method": "password", "alias": "web01", "options": {"ConnectTimeout": 2}}]}`
+	if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+// exec 的错误写到标准错误，标准输出只保留远程命令的输出
+func TestExecMain_errorsOnStderr(t *testing.T) {
+	configFile := writeExecTestConfig(t)
+
+	tests := []struct {
+		args []string
+		code int
+	}{
+		{[]string{"nope", "--", "uptime"}, ExitCodeConnectFailed},
+		{[]string{"web01", "--", "uptime"}, ExitCodeConnectFailed},
+		{[]string{"web01", "-x", "--", "uptime"}, 2},
+	}
+	for _, tt := range tests {
+		var code int
+		stdout, stderr := captureOutput(t, func() {
+			code = execMain(configFile, tt.args)
+		})
+		if code != tt.code {
+			t.Errorf("%v: exit code %d, want %d", tt.args, code, tt.code)
+		}
+		if stdout != "" {
+			t.Errorf("%v: stdout should be empty, got %q", tt.args, stdout)
+		}
+		if stderr == "" {
+			t.Errorf("%v: expected error on stderr", tt.args)
+		}
+	}
+}
+
+// run 的错误同样写到标准错误
+func TestRunMain_errorsOnStderr(t *testing.T) {
+	configFile := writeExecTestConfig(t)
+	script := filepath.Join(t.TempDir(), "script.sh")
+	if err := os.WriteFile(script, []byte("echo ok\n"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		args []string
+		code int
+	}{
+		{[]string{"nope", script}, ExitCodeConnectFailed},
+		{[]string{"web01", script}, ExitCodeConnectFailed},
+		{[]string{"web01"}, 2},
+	}
+	for _, tt := range tests {
+		var code int
+		stdout, stderr := captureOutput(t, func() {
+			code = runMain(configFile, tt.args)
+		})
+		if code != tt.code {
+			t.Errorf("%v: exit code %d, want %d", tt.args, code, tt.code)
+		}
+		if stdout != "" || stderr == "" {
+			t.Errorf("%v: expected error only on stderr, got stdout %q", tt.args, stdout)
+		}
+	}
+}
+
+// JSON 输出时，开始执行前的错误也以 JSON 写到标准输出
+func TestExecMain_jsonErrors(t *testing.T) {
+	configFile := writeExecTestConfig(t)
+
+	tests := []struct {
+		configFile string
+		args       []string
+		errorType  string
+	}{
+		{configFile, []string{"--output", "json", "-s", "nope", "--", "uptime"}, "server_not_found"},
+		{configFile, []string{"--output", "jsonl", "-s", "nope", "--", "uptime"}, "server_not_found"},
+		{configFile, []string{"--output", "json", "-t", "-s", "web01", "--", "uptime"}, "invalid_arguments"},
+		{filepath.Join(t.TempDir(), "missing.json"), []string{"--output", "json", "-s", "web01", "--", "uptime"}, "config_error"},
+	}
+	for _, tt := range tests {
+		var code int
+		stdout, _ := captureOutput(t, func() {
+			code = execMain(tt.configFile, tt.args)
+		})
+
+		var report ExecReport
+		if tt.args[1] == ExecOutputJSON {
+			var reports []ExecReport
+			if err := json.Unmarshal([]byte(stdout), &reports); err != nil || len(reports) != 1 {
+				t.Fatalf("%v: stdout is not a JSON report: %v\n%s", tt.args, err, stdout)
+			}
+			report = reports[0]
+		} else if err := json.Unmarshal([]byte(stdout), &report); err != nil {
+			t.Fatalf("%v: stdout is not a JSON report: %v\n%s", tt.args, err, stdout)
+		}
+
+		if report.ErrorType != tt.errorType || report.Error == "" || report.ExitCode != code {
+			t.Errorf("%v: unexpected report %+v (exit code %d)", tt.args, report, code)
+		}
+	}
+}
+
+func TestServer_RunScript(t *testing.T) {
+	server := startTestSSHServer(t)
+
+	// 测试服务器的 SFTP 以当前目录作为登录目录
+	home := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(home); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.Chdir(wd) })
+
+	script := filepath.Join(t.TempDir(), "hello.sh")
+	content := "#!/bin/sh\necho \"$1|$2\"\necho \"$0\" >&2\n"
+	if err := os.WriteFile(script, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, stream := range []bool{false, true} {
+		var stdout, stderr bytes.Buffer
+		code, err := server.RunScript(script, []string{"it's", "a b"}, stream, ExecOptions{Stdout: &stdout, Stderr: &stderr})
+		if err != nil || code != 0 {
+			t.Fatalf("stream=%v: code=%d err=%v", stream, code, err)
+		}
+		if stdout.String() != "it's|a b\n" {
+			t.Errorf("stream=%v: stdout %q", stream, stdout.String())
+		}
+
+		if !stream {
+			remotePath := strings.TrimSpace(stderr.String())
+			if !strings.HasPrefix(remotePath, filepath.Join(home, ".autossh-")) {
+				t.Fatalf("unexpected remote path %q", remotePath)
+			}
+			if _, err := os.Stat(remotePath); !os.IsNotExist(err) {
+				t.Errorf("temporary script %s was not removed", remotePath)
+			}
+		}
+	}
+}
+
+func TestServer_ExecInterrupt(t *testing.T) {
+	server := startTestSSHServer(t)
+
+	interrupt := make(chan struct{})
+	time.AfterFunc(100*time.Millisecond, func() { close(interrupt) })
+
+	start := time.Now()
+	code, err := server.Exec(ExecOptions{Command: "sleep 5", Interrupt: interrupt})
+	if code != 130 || err == nil {
+		t.Errorf("code=%d err=%v", code, err)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("interrupt took %v", elapsed)
+	}
+
+	// 连接池中的连接仍可继续使用
+	if code, err := server.Exec(ExecOptions{Command: "true"}); code != 0 || err != nil {
+		t.Errorf("code=%d err=%v", code, err)
+	}
+}
+
+func TestParseRunArgs(t *testing.T) {
+	rf, err := parseRunArgs([]string{"web01", "-stream", "./cleanup.sh", "--days", "7"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rf.alias != "web01" || rf.script != "./cleanup.sh" || !rf.stream || strings.Join(rf.args, " ") != "--days 7" {
+		t.Errorf("got %+v", rf)
+	}
+
+	if _, err := parseRunArgs([]string{"web01"}); err == nil {
+		t.Error("expected error without script")
+	}
+}
+
+// 全局与服务器中的 SendEnv 累加，SetEnv 按变量合并且服务器优先
+func TestServer_sessionEnvMerge(t *testing.T) {
+	t.Setenv("LANG", "zh_CN.UTF-8")
+	t.Setenv("LC_AUTOSSH_TEST", "C")
+	cfg := &Config{
+		Options: map[string]interface{}{
+			"SendEnv": []interface{}{"LANG"},
+			"SetEnv":  map[string]interface{}{"DEPLOY_ENV": "staging", "REGION": "cn"},
+		},
+		Servers: []*Server{
+			{Name: "a", Ip: "10.0.0.1", User: "root", Options: map[string]interface{}{
+				"SendEnv": "LC_*",
+				"SetEnv":  map[string]interface{}{"DEPLOY_ENV": "production"},
+			}},
+			{Name: "b", Ip: "10.0.0.2", User: "root"},
+		},
+	}
+	cfg.createServerIndex()
+
+	env := cfg.Servers[0].sessionEnv()
+	want := map[string]string{"LANG": "zh_CN.UTF-8", "LC_AUTOSSH_TEST": "C", "DEPLOY_ENV": "production", "REGION": "cn"}
+	if len(env) != len(want) {
+		t.Errorf("got %v, want %v", env, want)
+	}
+	for name, value := range want {
+		if env[name] != value {
+			t.Errorf("%s = %q, want %q", name, env[name], value)
+		}
+	}
+
+	if patterns := cfg.Servers[1].getSendEnv(); len(patterns) != 1 || patterns[0] != "LANG" {
+		t.Errorf("server without SendEnv should use the global patterns once, got %v", patterns)
+	}
+}
+
+func TestServer_setSessionEnv(t *testing.T) {
+	t.Setenv("LC_AUTOSSH_TEST", "zh_CN.UTF-8")
+	server := startTestSSHServer(t)
+	server.Options["SendEnv"] = []interface{}{"LC_*"}
+	server.Options["SetEnv"] = map[string]interface{}{"DEPLOY_ENV": "staging", "FOO": "bar"}
+
+	client, err := server.GetSshClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	if rejected := server.setSessionEnv(session); len(rejected) != 1 || rejected[0] != "FOO" {
+		t.Errorf("rejected = %v, want [FOO]", rejected)
+	}
+
+	output, err := session.Output(`echo "$DEPLOY_ENV|$LC_AUTOSSH_TEST|$FOO"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(output) != "staging|zh_CN.UTF-8|\n" {
+		t.Errorf("got %q", output)
+	}
+}
@@ -0,0 +1,323 @@
+package app
+
+import (
+	"autossh/src/utils"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// 主机密钥校验模式，取值与 OpenSSH 的 StrictHostKeyChecking 一致
+const (
+	HostKeyCheckStrict    = "yes"
+	HostKeyCheckAsk       = "ask"
+	HostKeyCheckAcceptNew = "accept-new"
+	HostKeyCheckOff       = "no"
+)
+
+var (
+	// 串行化终端提示（首次连接确认、认证问题），避免并发连接时提示交错
+	promptMutex sync.Mutex
+	// 本次运行中已确认的主机密钥，避免重复提示
+	acceptedHostKeys = make(map[string]bool)
+)
+
+func (server *Server) shouldSkipHostKeyCheck() bool {
+	return server.hostKeyCheckMode() == HostKeyCheckOff
+}
+
+// 获取主机密钥校验模式，未配置时默认为严格校验；ask/accept-new 需通过 StrictHostKeyChecking 显式开启
+func (server *Server) hostKeyCheckMode() string {
+	if insecureSkipHostKeyCheck {
+		return HostKeyCheckOff
+	}
+
+	if server.Options == nil {
+		return HostKeyCheckStrict
+	}
+
+	if val, ok := server.Options["InsecureSkipHostKeyChecking"]; ok {
+		if b, ok := toBool(val); ok && b {
+			return HostKeyCheckOff
+		}
+	}
+	if val, ok := server.Options["SkipHostKeyCheck"]; ok {
+		if b, ok := toBool(val); ok && b {
+			return HostKeyCheckOff
+		}
+	}
+	if val, ok := server.Options["StrictHostKeyChecking"]; ok {
+		if s, ok := val.(string); ok {
+			switch strings.ToLower(strings.TrimSpace(s)) {
+			case HostKeyCheckAsk:
+				return HostKeyCheckAsk
+			case HostKeyCheckAcceptNew:
+				return HostKeyCheckAcceptNew
+			case "no", "false", "0", "off":
+				return HostKeyCheckOff
+			}
+		}
+		if b, ok := toBool(val); ok {
+			if b {
+				return HostKeyCheckStrict
+			}
+			return HostKeyCheckOff
+		}
+	}
+
+	return HostKeyCheckStrict
+}
+
+// 是否以哈希形式写入 known_hosts
+func (server *Server) shouldHashKnownHosts() bool {
+	if val, ok := server.Options["HashKnownHosts"]; ok {
+		if b, ok := toBool(val); ok {
+			return b
+		}
+	}
+	return false
+}
+
+func (server *Server) getKnownHostsFile() (string, error) {
+	if server.Options != nil {
+		if val, ok := server.Options["KnownHostsFile"]; ok {
+			if s, ok := val.(string); ok && strings.TrimSpace(s) != "" {
+				return utils.ParsePath(s)
+			}
+		}
+	}
+	return utils.ParsePath("~/.ssh/known_hosts")
+}
+
+// 获取全局 known_hosts 文件，通常用于存放整个集群共用的 @cert-authority 记录
+func (server *Server) getGlobalKnownHostsFile() (string, error) {
+	if server.Options != nil {
+		if val, ok := server.Options["GlobalKnownHostsFile"]; ok {
+			if s, ok := val.(string); ok && strings.TrimSpace(s) != "" {
+				return utils.ParsePath(s)
+			}
+		}
+	}
+	return "/etc/ssh/ssh_known_hosts", nil
+}
+
+// 校验主机密钥
+// 同时支持普通主机密钥和由 @cert-authority 签发的主机证书；
+// ask/accept-new 模式下未知主机在确认后写入 known_hosts，密钥变更时始终拒绝连接
+func (server *Server) getHostKeyCallback() (ssh.HostKeyCallback, error) {
+	mode := server.hostKeyCheckMode()
+	if mode == HostKeyCheckOff {
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+
+	knownHostsFile, err := server.getKnownHostsFile()
+	if err != nil {
+		return nil, fmt.Errorf("解析 KnownHostsFile 失败: %w", err)
+	}
+
+	globalKnownHostsFile, err := server.getGlobalKnownHostsFile()
+	if err != nil {
+		return nil, fmt.Errorf("解析 GlobalKnownHostsFile 失败: %w", err)
+	}
+
+	var files []string
+	for _, file := range []string{knownHostsFile, globalKnownHostsFile} {
+		if _, err := os.Stat(file); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, fmt.Errorf("读取 known_hosts 失败: %w", err)
+		}
+		files = append(files, file)
+	}
+
+	var cb ssh.HostKeyCallback
+	if len(files) > 0 {
+		cb, err = knownhosts.New(files...)
+		if err != nil {
+			return nil, fmt.Errorf("解析 known_hosts 失败: %w", err)
+		}
+	} else if mode == HostKeyCheckStrict {
+		return nil, fmt.Errorf("known_hosts 文件不存在: %s（可使用 --insecure 跳过校验）", knownHostsFile)
+	} else {
+		// known_hosts 尚不存在，所有主机均视为未知主机
+		cb = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			return &knownhosts.KeyError{}
+		}
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := cb(hostname, remote, key)
+		if err == nil {
+			return nil
+		}
+
+		// 主机证书未被任何 CA 信任时，与 OpenSSH 一样退回校验证书中的普通密钥；
+		// 受信任的 CA 签发的证书过期或主体不符时直接拒绝
+		if cert, ok := key.(*ssh.Certificate); ok {
+			var keyErr *knownhosts.KeyError
+			if !errors.As(err, &keyErr) {
+				if certErr := checkHostCert(hostname, cert); certErr != nil && hasCertAuthority(files, cert.SignatureKey) {
+					return fmt.Errorf("SSH 主机证书无效: %s (%s): %w（CA 指纹: %s）", hostname, remote.String(), certErr, ssh.FingerprintSHA256(cert.SignatureKey))
+				}
+				if plainErr := cb(hostname, remote, cert.Key); plainErr == nil || errors.As(plainErr, &keyErr) {
+					err, key = plainErr, cert.Key
+				}
+			}
+			if err == nil {
+				return nil
+			}
+		}
+
+		var keyErr *knownhosts.KeyError
+		if errors.As(err, &keyErr) {
+			if len(keyErr.Want) > 0 {
+				return hostKeyChangedError(hostname, remote, key, keyErr)
+			}
+			if mode != HostKeyCheckStrict {
+				return server.trustNewHostKey(mode, knownHostsFile, hostname, remote, key)
+			}
+		}
+
+		fp := ssh.FingerprintSHA256(key)
+		if cert, ok := key.(*ssh.Certificate); ok {
+			fp = ssh.FingerprintSHA256(cert.SignatureKey)
+			return fmt.Errorf("SSH 主机证书校验失败: %s (%s): %w（CA 指纹: %s，known_hosts: %s，请确认已配置对应的 @cert-authority）", hostname, remote.String(), err, fp, strings.Join(files, ", "))
+		}
+		return fmt.Errorf("SSH HostKey 校验失败: %s (%s): %w（指纹: %s，known_hosts: %s，可用 --insecure 跳过）", hostname, remote.String(), err, fp, strings.Join(files, ", "))
+	}, nil
+}
+
+// 校验主机证书本身的有效期、主体与签名，不涉及 CA 是否受信任
+func checkHostCert(hostname string, cert *ssh.Certificate) error {
+	host, _, err := net.SplitHostPort(hostname)
+	if err != nil {
+		host = hostname
+	}
+	if cert.CertType != ssh.HostCert {
+		return fmt.Errorf("证书类型不是主机证书: %d", cert.CertType)
+	}
+	return (&ssh.CertChecker{}).CheckCert(host, cert)
+}
+
+// known_hosts 中是否有以 ca 为密钥的 @cert-authority 记录
+func hasCertAuthority(files []string, ca ssh.PublicKey) bool {
+	want := ca.Marshal()
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		for len(data) > 0 {
+			var marker string
+			var key ssh.PublicKey
+			marker, _, key, _, data, err = ssh.ParseKnownHosts(data)
+			if err != nil {
+				break
+			}
+			if marker == "cert-authority" && bytes.Equal(key.Marshal(), want) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// 首次连接：确认后将主机密钥追加到 known_hosts
+func (server *Server) trustNewHostKey(mode string, knownHostsFile string, hostname string, remote net.Addr, key ssh.PublicKey) error {
+	promptMutex.Lock()
+	defer promptMutex.Unlock()
+
+	fp := ssh.FingerprintSHA256(key)
+	if acceptedHostKeys[hostname+" "+fp] {
+		return nil
+	}
+
+	if mode == HostKeyCheckAsk {
+		fd := int(os.Stdin.Fd())
+		if !terminal.IsTerminal(fd) {
+			return fmt.Errorf("主机 %s 不在 known_hosts 中，非交互模式下无法确认（指纹: %s，可将 StrictHostKeyChecking 设置为 accept-new）", hostname, fp)
+		}
+
+		fmt.Fprintf(os.Stderr, "⚠️  无法确认主机 %s (%s) 的真实性。\n", hostname, remote.String())
+		fmt.Fprintf(os.Stderr, "🔑 %s 主机密钥指纹: %s\n", key.Type(), fp)
+		fmt.Fprint(os.Stderr, "❓ 确认继续连接并将其加入 known_hosts 吗？(yes/no): ")
+
+		var answer string
+		utils.Scanln(&answer)
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "yes", "y":
+		default:
+			return fmt.Errorf("主机密钥未被确认，已取消连接: %s", hostname)
+		}
+	}
+
+	if err := server.appendKnownHost(knownHostsFile, hostname, key); err != nil {
+		return err
+	}
+	acceptedHostKeys[hostname+" "+fp] = true
+
+	fmt.Fprintf(os.Stderr, "✅ 已将主机 %s 的密钥加入 %s\n", hostname, knownHostsFile)
+	return nil
+}
+
+// 追加一行 known_hosts 记录，可选对主机名做哈希处理
+func (server *Server) appendKnownHost(knownHostsFile string, hostname string, key ssh.PublicKey) error {
+	if err := os.MkdirAll(filepath.Dir(knownHostsFile), 0700); err != nil {
+		return fmt.Errorf("创建 known_hosts 目录失败: %w", err)
+	}
+
+	f, err := os.OpenFile(knownHostsFile, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("打开 known_hosts 失败: %w", err)
+	}
+	defer f.Close()
+
+	host := knownhosts.Normalize(hostname)
+	if server.shouldHashKnownHosts() {
+		host = knownhosts.HashHostname(host)
+	}
+	line := knownhosts.Line([]string{host}, key) + "\n"
+
+	// 原文件末尾没有换行时补一个，避免与上一条记录粘连
+	if info, err := f.Stat(); err == nil && info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := f.ReadAt(last, info.Size()-1); err == nil || err == io.EOF {
+			if last[0] != '\n' {
+				line = "\n" + line
+			}
+		}
+	}
+
+	if _, err := f.WriteString(line); err != nil {
+		return fmt.Errorf("写入 known_hosts 失败: %w", err)
+	}
+	return nil
+}
+
+// 主机密钥与已记录的不一致，可能存在中间人攻击
+func hostKeyChangedError(hostname string, remote net.Addr, key ssh.PublicKey, keyErr *knownhosts.KeyError) error {
+	var known []string
+	for _, want := range keyErr.Want {
+		known = append(known, fmt.Sprintf("%s:%d", want.Filename, want.Line))
+	}
+
+	fmt.Fprint(os.Stderr, "\033[31m"+
+		"@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@\n"+
+		"@    警告：远程主机身份已变更！可能存在中间人攻击！      @\n"+
+		"@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@\n"+
+		"\033[0m")
+
+	return fmt.Errorf("主机 %s (%s) 的密钥与 known_hosts 中的记录不一致，已拒绝连接（当前指纹: %s，已记录: %s）。如确认主机已重装，请先删除旧记录",
+		hostname, remote.String(), ssh.FingerprintSHA256(key), strings.Join(known, ", "))
+}
@@ -0,0 +1,239 @@
+package app
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func newTestSigner(t *testing.T) ssh.Signer {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+// 用 ca 为 key 签发证书，有效期为 [validAfter, validBefore)
+func newTestCert(t *testing.T, ca ssh.Signer, key ssh.PublicKey, certType uint32, principal string, validAfter, validBefore time.Time) *ssh.Certificate {
+	cert := &ssh.Certificate{
+		Key:             key,
+		CertType:        certType,
+		KeyId:           principal,
+		ValidPrincipals: []string{principal},
+		ValidAfter:      uint64(validAfter.Unix()),
+		ValidBefore:     uint64(validBefore.Unix()),
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func newTestHostKey(t *testing.T) ssh.PublicKey {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestHostKeyCallback_AcceptNew(t *testing.T) {
+	knownHostsFile := filepath.Join(t.TempDir(), "ssh", "known_hosts")
+	server := Server{
+		Options: map[string]interface{}{
+			"StrictHostKeyChecking": "accept-new",
+			"KnownHostsFile":        knownHostsFile,
+			"GlobalKnownHostsFile":  filepath.Join(t.TempDir(), "missing"),
+			"HashKnownHosts":        true,
+		},
+	}
+	remote := &net.TCPAddr{IP: net.ParseIP("192.0.2.10"), Port: 2222}
+	hostname := "example.test:2222"
+	key := newTestHostKey(t)
+
+	cb, err := server.getHostKeyCallback()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cb(hostname, remote, key); err != nil {
+		t.Fatalf("unknown host should be accepted: %v", err)
+	}
+
+	data, err := os.ReadFile(knownHostsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), "|1|") || strings.Contains(string(data), "example.test") {
+		t.Fatalf("expected hashed known_hosts entry, got %q", data)
+	}
+
+	// 重新加载 known_hosts 后，同一密钥通过，变更后的密钥被拒绝
+	cb, err = server.getHostKeyCallback()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cb(hostname, remote, key); err != nil {
+		t.Fatalf("known key should pass: %v", err)
+	}
+	if err := cb(hostname, remote, newTestHostKey(t)); err == nil || !strings.Contains(err.Error(), "不一致") {
+		t.Fatalf("changed key should be refused, got %v", err)
+	}
+}
+
+func TestHostKeyCallback_StrictMissingFile(t *testing.T) {
+	server := Server{
+		Options: map[string]interface{}{
+			"StrictHostKeyChecking": true,
+			"KnownHostsFile":        filepath.Join(t.TempDir(), "known_hosts"),
+			"GlobalKnownHostsFile":  filepath.Join(t.TempDir(), "missing"),
+		},
+	}
+	if _, err := server.getHostKeyCallback(); err == nil {
+		t.Fatal("strict mode should fail without known_hosts")
+	}
+}
+
+func TestServer_hostKeyCheckMode(t *testing.T) {
+	tests := []struct {
+		name    string
+		options map[string]interface{}
+		want    string
+	}{
+		{"未配置", nil, HostKeyCheckStrict},
+		{"无相关选项", map[string]interface{}{"ServerAliveInterval": 30}, HostKeyCheckStrict},
+		{"yes", map[string]interface{}{"StrictHostKeyChecking": "yes"}, HostKeyCheckStrict},
+		{"true", map[string]interface{}{"StrictHostKeyChecking": true}, HostKeyCheckStrict},
+		{"ask", map[string]interface{}{"StrictHostKeyChecking": "ask"}, HostKeyCheckAsk},
+		{"accept-new", map[string]interface{}{"StrictHostKeyChecking": "Accept-New"}, HostKeyCheckAcceptNew},
+		{"no", map[string]interface{}{"StrictHostKeyChecking": "no"}, HostKeyCheckOff},
+		{"false", map[string]interface{}{"StrictHostKeyChecking": false}, HostKeyCheckOff},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := Server{Options: tt.options}
+			if got := server.hostKeyCheckMode(); got != tt.want {
+				t.Errorf("hostKeyCheckMode() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// 全局 known_hosts 中的 @cert-authority 记录可信任该 CA 签发的主机证书
+func TestHostKeyCallback_CertAuthority(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestSigner(t)
+	plain := newTestHostKey(t)
+	globalKnownHosts := "@cert-authority *.example.test " + string(ssh.MarshalAuthorizedKey(ca.PublicKey())) +
+		knownhosts.Line([]string{"legacy.test"}, plain) + "\n"
+	if err := os.WriteFile(filepath.Join(dir, "ssh_known_hosts"), []byte(globalKnownHosts), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	server := Server{
+		Options: map[string]interface{}{
+			"KnownHostsFile":       filepath.Join(dir, "missing"),
+			"GlobalKnownHostsFile": filepath.Join(dir, "ssh_known_hosts"),
+		},
+	}
+	cb, err := server.getHostKeyCallback()
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote := &net.TCPAddr{IP: net.ParseIP("192.0.2.20"), Port: 22}
+	now := time.Now()
+
+	hostCert := newTestCert(t, ca, newTestHostKey(t), ssh.HostCert, "web.example.test", now.Add(-time.Hour), now.Add(time.Hour))
+	if err := cb("web.example.test:22", remote, hostCert); err != nil {
+		t.Errorf("host cert signed by trusted CA should pass: %v", err)
+	}
+
+	untrusted := newTestCert(t, newTestSigner(t), newTestHostKey(t), ssh.HostCert, "web.example.test", now.Add(-time.Hour), now.Add(time.Hour))
+	if err := cb("web.example.test:22", remote, untrusted); err == nil {
+		t.Error("host cert signed by unknown CA should be refused")
+	}
+
+	expired := newTestCert(t, ca, newTestHostKey(t), ssh.HostCert, "web.example.test", now.Add(-2*time.Hour), now.Add(-time.Hour))
+	if err := cb("web.example.test:22", remote, expired); err == nil {
+		t.Error("expired host cert should be refused")
+	}
+
+	// GlobalKnownHostsFile 中的普通主机密钥同样生效
+	if err := cb("legacy.test:22", remote, plain); err != nil {
+		t.Errorf("key from GlobalKnownHostsFile should pass: %v", err)
+	}
+	if err := cb("legacy.test:22", remote, newTestHostKey(t)); err == nil {
+		t.Error("unknown key should be refused in strict mode")
+	}
+}
+
+// 受信任的 CA 签发的证书无效时不能退回证书中的普通密钥，即使该密钥已记录或允许首次信任
+func TestHostKeyCallback_CertAuthorityInvalidCert(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestSigner(t)
+	hostKey := newTestHostKey(t)
+	knownHostsFile := filepath.Join(dir, "known_hosts")
+	content := knownhosts.Line([]string{"web.example.test"}, hostKey) + "\n" +
+		"@cert-authority *.example.test " + string(ssh.MarshalAuthorizedKey(ca.PublicKey()))
+	if err := os.WriteFile(knownHostsFile, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	server := Server{
+		Options: map[string]interface{}{
+			"StrictHostKeyChecking": HostKeyCheckAcceptNew,
+			"KnownHostsFile":        knownHostsFile,
+			"GlobalKnownHostsFile":  filepath.Join(dir, "missing"),
+		},
+	}
+	cb, err := server.getHostKeyCallback()
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote := &net.TCPAddr{IP: net.ParseIP("192.0.2.20"), Port: 22}
+	now := time.Now()
+
+	expired := newTestCert(t, ca, hostKey, ssh.HostCert, "web.example.test", now.Add(-2*time.Hour), now.Add(-time.Hour))
+	if err := cb("web.example.test:22", remote, expired); err == nil {
+		t.Error("expired host cert from trusted CA should be refused")
+	}
+	wrongPrincipal := newTestCert(t, ca, hostKey, ssh.HostCert, "db.example.test", now.Add(-time.Hour), now.Add(time.Hour))
+	if err := cb("web.example.test:22", remote, wrongPrincipal); err == nil {
+		t.Error("host cert from trusted CA with wrong principal should be refused")
+	}
+	newHostKey := newTestHostKey(t)
+	expiredNew := newTestCert(t, ca, newHostKey, ssh.HostCert, "api.example.test", now.Add(-2*time.Hour), now.Add(-time.Hour))
+	if err := cb("api.example.test:22", remote, expiredNew); err == nil {
+		t.Error("expired host cert from trusted CA should not be trusted as a new key")
+	}
+
+	// 未受信任的 CA 签发的证书仍可按普通密钥校验
+	untrusted := newTestCert(t, newTestSigner(t), hostKey, ssh.HostCert, "web.example.test", now.Add(-time.Hour), now.Add(time.Hour))
+	if err := cb("web.example.test:22", remote, untrusted); err != nil {
+		t.Errorf("cert from untrusted CA should fall back to the recorded key: %v", err)
+	}
+
+	data, err := os.ReadFile(knownHostsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != content {
+		t.Errorf("known_hosts should be unchanged, got:\n%s", data)
+	}
+}
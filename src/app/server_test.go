@@ -1,11 +1,18 @@
 package app
 
 import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/net/proxy"
 	"net"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestServer_Connect(t *testing.T) {
@@ -17,10 +24,11 @@ func TestServer_Connect(t *testing.T) {
 		Ip:     "172.18.36.217",
 		Method: "key",
 	}
-	auth, err := parseAuthMethods(&server)
+	auth, release, err := parseAuthMethods(&server)
 	if err != nil {
 		t.Fatal(err)
 	}
+	defer release()
 	sshConfig := &ssh.ClientConfig{
 		User: "work",
 		Auth: auth,
@@ -61,3 +69,279 @@ func proxiedSSHClient(proxyAddress, sshServerAddress string, sshConfig *ssh.Clie
 
 	return ssh.NewClient(c, chans, reqs), nil
 }
+
+func TestServer_resolveJumpHosts(t *testing.T) {
+	cfg := &Config{
+		Servers: []*Server{
+			{Name: "bastion1", Alias: "b1"},
+			{Name: "bastion2", Alias: "b2", ProxyJump: []string{"b1"}},
+			{Name: "target", Alias: "app", ProxyJump: []string{"b1", "2"}},
+			{Name: "loop1", Alias: "l1", ProxyJump: []string{"l2"}},
+			{Name: "loop2", Alias: "l2", ProxyJump: []string{"l1"}},
+			{Name: "missing", Alias: "m", ProxyJump: []string{"nope"}},
+		},
+	}
+	cfg.createServerIndex()
+
+	hops, err := cfg.serverIndex["app"].server.resolveJumpHosts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hops) != 2 || hops[0].Name != "bastion1" || hops[1].Name != "bastion2" {
+		t.Fatalf("unexpected hops: %v", hops)
+	}
+
+	if _, err := cfg.serverIndex["l1"].server.resolveJumpHosts(); err == nil {
+		t.Error("expected loop to be detected")
+	}
+	if _, err := cfg.serverIndex["m"].server.resolveJumpHosts(); err == nil {
+		t.Error("expected unknown jump host error")
+	}
+}
+
+func TestServer_forwardAgentMode(t *testing.T) {
+	tests := []struct {
+		value     interface{}
+		untrusted bool
+		want      string
+	}{
+		{nil, false, ForwardAgentNo},
+		{true, false, ForwardAgentYes},
+		{"ask", false, ForwardAgentAsk},
+		{true, true, ForwardAgentAsk},
+		{false, true, ForwardAgentNo},
+	}
+
+	for _, tt := range tests {
+		server := &Server{Options: map[string]interface{}{}, group: &Group{Untrusted: tt.untrusted}}
+		if tt.value != nil {
+			server.Options["ForwardAgent"] = tt.value
+		}
+		if got := server.forwardAgentMode(); got != tt.want {
+			t.Errorf("ForwardAgent=%v untrusted=%v: got %s, want %s", tt.value, tt.untrusted, got, tt.want)
+		}
+	}
+
+	if err := validateOptions(map[string]interface{}{"ForwardAgent": "sometimes"}); err == nil {
+		t.Error("expected validation error")
+	}
+}
+
+// 证书与密钥同名（<key>-cert.pub）时自动加载，并优先于原始密钥提供
+func TestLoadCertificate(t *testing.T) {
+	dir := t.TempDir()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(dir, "id_ed25519")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ca := newTestSigner(t)
+	now := time.Now()
+	writeCert := func(name string, validAfter, validBefore time.Time) string {
+		cert := newTestCert(t, ca, signer.PublicKey(), ssh.UserCert, "deploy", validAfter, validBefore)
+		file := filepath.Join(dir, name)
+		if err := os.WriteFile(file, ssh.MarshalAuthorizedKey(cert), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return file
+	}
+
+	writeCert("id_ed25519-cert.pub", now.Add(-time.Hour), now.Add(time.Hour))
+	signers, err := keySigners(&Server{Key: keyFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(signers) != 2 {
+		t.Fatalf("expected cert and key signers, got %d", len(signers))
+	}
+	if cert, ok := signers[0].PublicKey().(*ssh.Certificate); !ok || cert.KeyId != "deploy" {
+		t.Errorf("first signer should be the certificate, got %T", signers[0].PublicKey())
+	}
+
+	for _, tc := range []struct {
+		name        string
+		validAfter  time.Time
+		validBefore time.Time
+		message     string
+	}{
+		{"expired-cert.pub", now.Add(-2 * time.Hour), now.Add(-time.Hour), "已过期"},
+		{"future-cert.pub", now.Add(time.Hour), now.Add(2 * time.Hour), "尚未生效"},
+	} {
+		server := &Server{Key: keyFile, Cert: writeCert(tc.name, tc.validAfter, tc.validBefore)}
+		if _, err := loadCertificate(server); err == nil || !strings.Contains(err.Error(), tc.message) {
+			t.Errorf("%s: expected %q error, got %v", tc.name, tc.message, err)
+		}
+	}
+}
+
+// keyboard-interactive 认证：密码提示使用配置的密码，动态码提示使用 totp_secret 生成，
+// 其他问题需要在终端中回答，非交互环境下认证失败
+func TestServer_keyboardInteractive(t *testing.T) {
+	server := startTestSSHServer(t)
+	server.Method = "keyboard-interactive"
+	server.TotpSecret = testTotpSecret
+	if err := server.validate(); err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+
+	var stdout strings.Builder
+	code, err := server.Exec(ExecOptions{Command: "echo ok", Stdout: &stdout})
+	if err != nil || code != 0 || stdout.String() != "ok\n" {
+		t.Fatalf("code=%d err=%v stdout=%q", code, err, stdout.String())
+	}
+
+	prompt := *server
+	prompt.User = "prompt"
+	if _, err := prompt.Exec(ExecOptions{Command: "echo ok", Stdout: &stdout}); err == nil || !strings.Contains(err.Error(), "Favorite color") {
+		t.Errorf("expected terminal prompt to fail without a terminal, got %v", err)
+	}
+
+	invalid := Server{Name: "a", Ip: "10.0.0.1", Port: 22, User: "root", Method: "keyboard-interactive", TotpSecret: "not base32!"}
+	if err := invalid.validate(); err == nil || !strings.Contains(err.Error(), "totp_secret 无效") {
+		t.Errorf("expected invalid totp_secret error, got %v", err)
+	}
+}
+
+// 服务器不响应心跳时，连续 ServerAliveCountMax 个周期后判定连接断开
+func TestServer_startKeepAliveLoop(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKey, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(hostKey)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		_, chans, reqs, err := ssh.NewServerConn(conn, config)
+		if err != nil {
+			return
+		}
+		go func() {
+			for range chans {
+			}
+		}()
+		// 收到心跳后不回复
+		for range reqs {
+		}
+	}()
+
+	client, err := ssh.Dial("tcp", ln.Addr().String(), &ssh.ClientConfig{
+		User:            "tester",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	server := &Server{Options: map[string]interface{}{"ServerAliveInterval": 1.0, "ServerAliveCountMax": 1.0}}
+	terminate, dead := server.startKeepAliveLoop(client)
+	defer close(terminate)
+
+	select {
+	case <-dead:
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection was not marked dead")
+	}
+	if _, _, err := client.SendRequest("ping", true, nil); err == nil {
+		t.Error("client should be closed")
+	}
+}
+
+func TestValidateOptions(t *testing.T) {
+	tests := []struct {
+		options map[string]interface{}
+		wantErr bool
+	}{
+		{map[string]interface{}{"Compression": true}, true},
+		{map[string]interface{}{"Compression": "yes"}, true},
+		{map[string]interface{}{"Compression": false}, false},
+		{map[string]interface{}{"TCPKeepAlive": "sometimes"}, true},
+		{map[string]interface{}{"TCPKeepAlive": []interface{}{true}}, true},
+		{map[string]interface{}{"TCPKeepAlive": "yes"}, false},
+		{map[string]interface{}{"TCPKeepAlive": false}, false},
+	}
+
+	for _, tt := range tests {
+		if err := validateOptions(tt.options); (err != nil) != tt.wantErr {
+			t.Errorf("%v: err = %v, wantErr %v", tt.options, err, tt.wantErr)
+		}
+	}
+
+	cfg := &Config{Options: map[string]interface{}{"Compression": true}}
+	if err := cfg.validate(); err == nil || !strings.Contains(err.Error(), "Compression") {
+		t.Errorf("global Compression: true should fail config validation, got %v", err)
+	}
+}
+
+func TestServer_ExecAgentAuth(t *testing.T) {
+	key, openConns := startTestAgent(t)
+	server := startTestSSHServerWithKeys(t, key)
+	server.Password = ""
+	server.Method = "agent"
+
+	var stdout bytes.Buffer
+	code, err := server.Exec(ExecOptions{Command: "echo ok", Stdout: &stdout})
+	if err != nil || code != 0 || stdout.String() != "ok\n" {
+		t.Fatalf("code=%d err=%v stdout=%q", code, err, stdout.String())
+	}
+
+	// 握手结束后 agent 连接即被关闭
+	deadline := time.Now().Add(time.Second)
+	for openConns() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d ssh-agent connection(s) left open", openConns())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// 按配置顺序尝试认证方法：密码错误后继续使用 agent，方法名大小写与空白不影响校验和认证
+func TestServer_ExecAuthFallback(t *testing.T) {
+	key, _ := startTestAgent(t)
+	server := startTestSSHServerWithKeys(t, key)
+	server.Password = "wrong"
+	server.Method = ""
+	server.Methods = []string{" Password", "AGENT "}
+
+	if err := server.validate(); err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+
+	var stdout bytes.Buffer
+	code, err := server.Exec(ExecOptions{Command: "echo ok", Stdout: &stdout})
+	if err != nil || code != 0 || stdout.String() != "ok\n" {
+		t.Fatalf("code=%d err=%v stdout=%q", code, err, stdout.String())
+	}
+
+	server.Methods = []string{"password", "totp"}
+	if err := server.validate(); err == nil {
+		t.Error("expected unsupported method error")
+	}
+}
@@ -0,0 +1,169 @@
+package app
+
+import (
+	"autossh/src/utils"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// 默认同时执行的服务器数量
+const defaultExecParallel = 5
+
+// exec 命令行参数
+type execFlags struct {
+	alias     string
+	command   string
+	tty       bool
+	noStdin   bool
+	sudo      bool
+	group     string
+	selection string
+	parallel  int
+	output    string
+}
+
+// 非交互执行远程命令，以远程命令的退出码退出
+//
+//	autossh exec [-t] [-n] [--sudo] <alias> -- <command...>
+//	autossh exec -g <prefix> [-p 5] -- <command...>
+//	autossh exec -s web01,web02,3 -- <command...>
+//	autossh exec --output json|jsonl -g <prefix> -- <command...>
+//	autossh <alias> <command...>
+func showExec(configFile string, args []string) {
+	os.Exit(execMain(configFile, args))
+}
+
+// 执行 exec 命令并返回退出码
+func execMain(configFile string, args []string) int {
+	ea, err := parseExecArgs(args)
+	if err != nil {
+		return execFail(ea, "invalid_arguments", err, 2)
+	}
+
+	cfg, err := loadConfig(configFile)
+	if err != nil {
+		return execFail(ea, "config_error", err, ExitCodeConnectFailed)
+	}
+
+	if ea.alias == "" || ea.output != ExecOutputText {
+		selection := ea.selection
+		if ea.alias != "" {
+			selection = ea.alias
+		}
+		targets, err := cfg.execTargets(ea.group, selection)
+		if err != nil {
+			return execFail(ea, "server_not_found", err, ExitCodeConnectFailed)
+		}
+		return execBatch(targets, ea)
+	}
+
+	index, ok := cfg.serverIndex[ea.alias]
+	if !ok {
+		utils.Stderrln("服务器" + ea.alias + "不存在")
+		return ExitCodeConnectFailed
+	}
+
+	opts := ExecOptions{
+		Command: ea.command,
+		Tty:     ea.tty,
+		Sudo:    ea.sudo,
+		Stdin:   os.Stdin,
+		Stdout:  os.Stdout,
+		Stderr:  os.Stderr,
+	}
+	if ea.noStdin {
+		opts.Stdin = nil
+	}
+
+	code, err := index.server.Exec(opts)
+	if err != nil {
+		utils.Stderrln(err)
+	}
+	return code
+}
+
+// 开始执行前的错误，JSON 输出时按执行结果的格式写到标准输出，保证标准输出始终可以解析
+func execFail(ea execFlags, errType string, err error, code int) int {
+	if ea.output != ExecOutputJSON && ea.output != ExecOutputJSONL {
+		utils.Stderrln(err)
+		return code
+	}
+
+	report := ExecReport{Command: ea.command, ExitCode: code, Error: err.Error(), ErrorType: errType}
+	encoder := json.NewEncoder(os.Stdout)
+	if ea.output == ExecOutputJSON {
+		encoder.SetIndent("", "  ")
+		_ = encoder.Encode([]ExecReport{report})
+	} else {
+		_ = encoder.Encode(report)
+	}
+	return code
+}
+
+// 解析 exec 参数，服务器可以写在选项之前或之后，-- 之后的内容全部作为命令
+// 指定 -g 或 -s 时在多台服务器上并行执行，不再接受单个服务器参数
+func parseExecArgs(args []string) (execFlags, error) {
+	var ea execFlags
+
+	fs := flag.NewFlagSet("exec", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.BoolVar(&ea.tty, "t", false, "")
+	fs.BoolVar(&ea.noStdin, "n", false, "")
+	fs.BoolVar(&ea.sudo, "sudo", false, "")
+	fs.StringVar(&ea.group, "g", "", "")
+	fs.StringVar(&ea.selection, "s", "", "")
+	fs.IntVar(&ea.parallel, "p", defaultExecParallel, "")
+	fs.StringVar(&ea.output, "output", ExecOutputText, "")
+
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		ea.alias = args[0]
+		args = args[1:]
+	}
+	if err := fs.Parse(args); err != nil {
+		return ea, err
+	}
+
+	batch := ea.group != "" || ea.selection != ""
+	rest := fs.Args()
+	if !batch && ea.alias == "" && len(rest) > 0 && rest[0] != "--" {
+		ea.alias, rest = rest[0], rest[1:]
+	}
+	if len(rest) > 0 && rest[0] == "--" {
+		rest = rest[1:]
+	}
+
+	switch ea.output {
+	case ExecOutputText:
+	case ExecOutputJSON, ExecOutputJSONL:
+		if ea.tty {
+			return ea, errors.New("JSON 输出时不支持 -t")
+		}
+	default:
+		return ea, fmt.Errorf("不支持的输出格式: %s（可选 text、json、jsonl）", ea.output)
+	}
+
+	if batch {
+		if ea.alias != "" {
+			return ea, errors.New("-g/-s 不能与单个服务器同时指定")
+		}
+		if ea.tty {
+			return ea, errors.New("批量执行时不支持 -t")
+		}
+		if ea.parallel <= 0 {
+			return ea, errors.New("-p 必须大于 0")
+		}
+	} else if ea.alias == "" {
+		return ea, errors.New("请指定服务器编号或别名，例如：autossh exec web01 -- uptime")
+	}
+	if len(rest) == 0 {
+		return ea, errors.New("请指定要执行的命令，例如：autossh exec web01 -- uptime")
+	}
+	ea.command = strings.Join(rest, " ")
+
+	return ea, nil
+}
@@ -0,0 +1,94 @@
+package app
+
+import (
+	"autossh/src/utils"
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"strings"
+)
+
+// run 命令行参数
+type runFlags struct {
+	alias  string
+	script string
+	args   []string
+	tty    bool
+	stream bool
+	sudo   bool
+}
+
+// 上传并执行本地脚本，以脚本的退出码退出
+//
+//	autossh run [-t] [-stream] [--sudo] <alias> <script> [args...]
+func showRun(configFile string, args []string) {
+	os.Exit(runMain(configFile, args))
+}
+
+// 执行 run 命令并返回退出码
+func runMain(configFile string, args []string) int {
+	cfg, err := loadConfig(configFile)
+	if err != nil {
+		utils.Stderrln(err)
+		return ExitCodeConnectFailed
+	}
+
+	rf, err := parseRunArgs(args)
+	if err != nil {
+		utils.Stderrln(err)
+		return 2
+	}
+
+	index, ok := cfg.serverIndex[rf.alias]
+	if !ok {
+		utils.Stderrln("服务器" + rf.alias + "不存在")
+		return ExitCodeConnectFailed
+	}
+
+	code, err := index.server.RunScript(rf.script, rf.args, rf.stream, ExecOptions{
+		Tty:    rf.tty,
+		Sudo:   rf.sudo,
+		Stdin:  os.Stdin,
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+	})
+	if err != nil {
+		utils.Stderrln(err)
+	}
+	return code
+}
+
+// 解析 run 参数，脚本之后的内容全部作为脚本参数
+func parseRunArgs(args []string) (runFlags, error) {
+	var rf runFlags
+
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.BoolVar(&rf.tty, "t", false, "")
+	fs.BoolVar(&rf.stream, "stream", false, "")
+	fs.BoolVar(&rf.sudo, "sudo", false, "")
+
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		rf.alias = args[0]
+		args = args[1:]
+	}
+	if err := fs.Parse(args); err != nil {
+		return rf, err
+	}
+
+	rest := fs.Args()
+	if rf.alias == "" && len(rest) > 0 {
+		rf.alias, rest = rest[0], rest[1:]
+	}
+	if len(rest) > 0 && rest[0] == "--" {
+		rest = rest[1:]
+	}
+
+	if rf.alias == "" || len(rest) == 0 {
+		return rf, errors.New("用法: autossh run <服务器> <脚本> [参数...]")
+	}
+	rf.script, rf.args = rest[0], rest[1:]
+
+	return rf, nil
+}
@@ -0,0 +1,188 @@
+package app
+
+import (
+	"autossh/src/utils"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	tunnelMinBackoff = time.Second
+	tunnelMaxBackoff = time.Minute
+
+	// 隧道模式未配置心跳时使用的默认间隔（秒），用于及时发现失效连接
+	tunnelDefaultAliveInterval = 30
+)
+
+// 仅转发模式：不请求PTY和Shell，保持前台运行，连接断开后按指数退避自动重连
+// 本地监听在重连期间保持打开，客户端只需重试即可
+func showTunnel(configFile string, args []string) {
+	cfg, err := loadConfig(configFile)
+	if err != nil {
+		utils.Errorln(err)
+		return
+	}
+
+	alias, err := parseTunnelArgs(args)
+	if err != nil {
+		utils.Errorln(err)
+		return
+	}
+
+	index, ok := cfg.serverIndex[alias]
+	if !ok {
+		utils.Errorln("服务器" + alias + "不存在")
+		return
+	}
+	server := index.server
+
+	forwards := server.allForwards()
+	if len(forwards) == 0 {
+		utils.Errorln("未指定任何端口转发，请使用 -L/-R/-D 或在配置中设置 forwards")
+		return
+	}
+
+	server.MergeOptions(map[string]interface{}{"ServerAliveInterval": float64(tunnelDefaultAliveInterval)}, false)
+
+	fw := newForwarder(forwards)
+	if err := fw.start(); err != nil {
+		utils.Errorln(err)
+		return
+	}
+	defer fw.close()
+
+	for _, f := range forwards {
+		tunnelLogf("🔀 %s", f)
+	}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+
+	stop := make(chan struct{})
+	go func() {
+		<-sigCh
+		close(stop)
+	}()
+
+	if err := runTunnel(server, fw, stop); err != nil {
+		utils.Errorln(err)
+		return
+	}
+	tunnelLogf("👋 隧道已关闭")
+}
+
+// 保持隧道连接直到 stop 关闭，连接断开后按指数退避重连
+// 首次连接时服务器拒绝远程监听属于配置问题，重试也不会成功，直接返回错误
+func runTunnel(server *Server, fw *forwarder, stop <-chan struct{}) error {
+	backoff := tunnelMinBackoff
+	// 首次建立前的失败不算重连，断开后才按重试次数提示
+	established := false
+	reconnects := 0
+	for {
+		client, err := server.GetSshClient()
+		if err == nil {
+			if err = fw.setClient(client); err != nil {
+				client.Close()
+				if !established {
+					return err
+				}
+			}
+		}
+
+		if err != nil {
+			tunnelLogf("❌ 连接 %s 失败: %v，%s 后重试", server.Name, err, backoff)
+			select {
+			case <-stop:
+				return nil
+			case <-time.After(backoff):
+			}
+
+			backoff *= 2
+			if backoff > tunnelMaxBackoff {
+				backoff = tunnelMaxBackoff
+			}
+			reconnects++
+			continue
+		}
+
+		if !established {
+			tunnelLogf("✅ 隧道已建立: %s@%s，按 Ctrl+C 退出", server.User, server.address())
+		} else {
+			tunnelLogf("🔄 已重新连接（第 %d 次重试）", reconnects)
+		}
+		established = true
+		backoff = tunnelMinBackoff
+		reconnects = 0
+
+		stopKeepAliveLoop, dead := server.startKeepAliveLoop(client)
+		closed := make(chan struct{})
+		go func() {
+			_ = client.Wait()
+			close(closed)
+		}()
+
+		select {
+		case <-stop:
+			close(stopKeepAliveLoop)
+			_ = fw.setClient(nil)
+			client.Close()
+			return nil
+		case <-closed:
+		}
+
+		close(stopKeepAliveLoop)
+		_ = fw.setClient(nil)
+
+		select {
+		case <-dead:
+			tunnelLogf("⚠️  服务器无响应，连接已断开，正在重连...")
+		default:
+			tunnelLogf("⚠️  连接已断开，正在重连...")
+		}
+		reconnects = 1
+	}
+}
+
+// 解析 tunnel 参数，支持 tunnel <alias> -L ... 与 tunnel -L ... <alias> 两种写法
+func parseTunnelArgs(args []string) (string, error) {
+	fs := flag.NewFlagSet("tunnel", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.Var(forwardFlag{parse: parseLocalForward}, "L", "")
+	fs.Var(forwardFlag{parse: parseRemoteForward}, "R", "")
+	fs.Var(forwardFlag{parse: parseDynamicForward}, "D", "")
+
+	var alias string
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		alias = args[0]
+		args = args[1:]
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return "", err
+	}
+
+	rest := fs.Args()
+	if alias == "" && len(rest) > 0 {
+		alias, rest = rest[0], rest[1:]
+	}
+	if alias == "" {
+		return "", errors.New("请指定服务器编号或别名，例如：autossh tunnel db01 -L 5432:127.0.0.1:5432")
+	}
+	if len(rest) > 0 {
+		return "", fmt.Errorf("无法识别的参数: %s", strings.Join(rest, " "))
+	}
+
+	return alias, nil
+}
+
+func tunnelLogf(format string, args ...interface{}) {
+	utils.Logf("[%s] %s", time.Now().Format("15:04:05"), fmt.Sprintf(format, args...))
+}
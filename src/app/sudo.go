@@ -0,0 +1,191 @@
+package app
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"sync"
+)
+
+// sudo 自动应答的事件
+const (
+	sudoSendPassword = iota
+	sudoReady
+	sudoAbort
+)
+
+// 执行命令时使用的 sudo 密码：优先 sudo_password，其次在允许时复用登录密码
+func (server *Server) sudoPassword() string {
+	if server.SudoPassword != "" {
+		return server.SudoPassword
+	}
+	if server.SudoUseLoginPassword {
+		return server.Password
+	}
+	return ""
+}
+
+// 以 sudo 执行命令并自动输入密码
+// 命令被包装为 sudo -p <prompt> -- "$SHELL" -c 'printf <ready> >&2; <command>'，
+// 即以登录用户的 shell 在 root 权限下执行，与不加 sudo 时的命令语法一致；
+// 输出中出现 prompt 时写入密码，出现 ready 表示认证完成，此后才转发本地标准输入。
+// 密码只写入标准输入，prompt 与 ready 标记会从输出中去掉，因此不会出现在终端与会话日志中
+type sudoResponder struct {
+	prompt   []byte
+	ready    []byte
+	password string
+	tty      bool
+
+	mu      sync.Mutex
+	out     io.Writer
+	buf     []byte
+	done    bool
+	aborted bool
+	prompts int
+	events  chan int
+
+	pr *io.PipeReader
+}
+
+// rest 为认证完成后转发的标准输入，可以为空
+func newSudoResponder(password string, tty bool, rest io.Reader) (*sudoResponder, error) {
+	token := make([]byte, 8)
+	if _, err := rand.Read(token); err != nil {
+		return nil, err
+	}
+	id := hex.EncodeToString(token)
+
+	pr, pw := io.Pipe()
+	r := &sudoResponder{
+		prompt:   []byte("[autossh-sudo-" + id + "]"),
+		ready:    []byte("[autossh-ready-" + id + "]"),
+		password: password,
+		tty:      tty,
+		events:   make(chan int, 4),
+		pr:       pr,
+	}
+
+	go func() {
+		defer pw.Close()
+		for event := range r.events {
+			switch event {
+			case sudoSendPassword:
+				if _, err := pw.Write([]byte(r.password + "\n")); err != nil {
+					return
+				}
+			case sudoReady:
+				if rest != nil {
+					_, _ = io.Copy(pw, rest)
+				}
+				return
+			case sudoAbort:
+				// PTY 中 sudo 从终端读取密码，发送 Ctrl+C 使其退出
+				if r.tty {
+					_, _ = pw.Write([]byte{3})
+				}
+				return
+			}
+		}
+	}()
+
+	return r, nil
+}
+
+// 包装要执行的命令，无PTY时使用 -S 从标准输入读取密码
+func (r *sudoResponder) wrap(command string) string {
+	inner := "printf '%s\\n' " + shellQuote(string(r.ready)) + " >&2; " + command
+
+	sudo := "sudo"
+	if !r.tty {
+		sudo += " -S"
+	}
+	return sudo + " -p " + shellQuote(string(r.prompt)) + ` -- "$SHELL" -c ` + shellQuote(inner)
+}
+
+func (r *sudoResponder) Read(p []byte) (int, error) {
+	return r.pr.Read(p)
+}
+
+// 包装 sudo 提示所在的输出流（无PTY时为标准错误，有PTY时为标准输出）
+func (r *sudoResponder) writer(w io.Writer) io.Writer {
+	r.out = w
+	return sudoWriter{r: r}
+}
+
+type sudoWriter struct {
+	r *sudoResponder
+}
+
+func (sw sudoWriter) Write(p []byte) (int, error) {
+	r := sw.r
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.done {
+		return r.out.Write(p)
+	}
+
+	// 认证完成前缓存输出，sudo 的提示与错误信息在此期间产生
+	r.buf = append(r.buf, p...)
+	for {
+		i := bytes.Index(r.buf, r.prompt)
+		if i < 0 {
+			break
+		}
+		r.buf = append(r.buf[:i], r.buf[i+len(r.prompt):]...)
+		r.prompts++
+
+		// 只尝试一次，密码错误时放弃，避免触发账户锁定
+		if r.prompts == 1 && r.password != "" {
+			r.events <- sudoSendPassword
+		} else if !r.aborted {
+			r.aborted = true
+			r.events <- sudoAbort
+		}
+	}
+
+	i := bytes.Index(r.buf, r.ready)
+	if i < 0 {
+		return len(p), nil
+	}
+	rest := r.buf[i+len(r.ready):]
+	rest = bytes.TrimPrefix(rest, []byte("\r"))
+	rest = bytes.TrimPrefix(rest, []byte("\n"))
+	pending := append(r.buf[:i:i], rest...)
+
+	r.done = true
+	r.buf = nil
+	r.events <- sudoReady
+
+	if _, err := r.out.Write(pending); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// 命令结束后输出未完成认证时缓存的内容，并返回认证失败的原因
+func (r *sudoResponder) finish() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.done {
+		return nil
+	}
+	r.done = true
+	close(r.events)
+	if len(r.buf) > 0 {
+		_, _ = r.out.Write(r.buf)
+		r.buf = nil
+	}
+
+	switch {
+	case r.prompts == 0:
+		return nil
+	case r.password == "":
+		return errors.New("sudo 需要密码，请配置 sudo_password 或 sudo_use_login_password")
+	default:
+		return errors.New("sudo 认证失败，请检查 sudo_password")
+	}
+}
@@ -0,0 +1,144 @@
+package app
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// 模拟 sudo：输出 -p 指定的提示并校验密码，错误时再提示一次后失败；
+// 第二次输入中收到 Ctrl+C 时与终端中的 sudo 一样立即退出
+const fakeSudo = `#!/bin/sh
+prompt=
+while [ $# -gt 0 ]; do
+  case "$1" in
+    -S) shift ;;
+    -p) prompt="$2"; shift 2 ;;
+    --) shift; break ;;
+    *) break ;;
+  esac
+done
+printf '%s' "$prompt" >&2
+read -r pw || exit 1
+if [ "$pw" != "hunter2" ]; then
+  echo "Sorry, try again." >&2
+  printf '%s' "$prompt" >&2
+  read -r pw
+  case "$pw" in *"$(printf '\003')"*) echo "^C" >&2; exit 1 ;; esac
+  echo "sudo: 1 incorrect password attempt" >&2
+  exit 1
+fi
+exec "$@"
+`
+
+func installFakeSudo(t *testing.T) {
+	t.Helper()
+	bin := t.TempDir()
+	if err := os.WriteFile(filepath.Join(bin, "sudo"), []byte(fakeSudo), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("SHELL", "/bin/sh")
+}
+
+func TestServer_ExecSudo(t *testing.T) {
+	installFakeSudo(t)
+
+	server := startTestSSHServer(t)
+	server.SudoPassword = "hunter2"
+	server.Log = ServerLog{Enable: true, Filename: filepath.Join(t.TempDir(), "session.log"), Mode: LogModeCover}
+
+	var stdout, stderr bytes.Buffer
+	code, err := server.Exec(ExecOptions{
+		Command: "cat; echo done",
+		Sudo:    true,
+		Stdin:   strings.NewReader("payload\n"),
+		Stdout:  &stdout,
+		Stderr:  &stderr,
+	})
+	if err != nil || code != 0 {
+		t.Fatalf("code=%d err=%v stderr=%q", code, err, stderr.String())
+	}
+	if stdout.String() != "payload\ndone\n" || stderr.String() != "" {
+		t.Errorf("stdout = %q, stderr = %q", stdout.String(), stderr.String())
+	}
+
+	logged, err := os.ReadFile(server.Log.Filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(logged) != "payload\ndone\n" {
+		t.Errorf("log = %q", logged)
+	}
+
+	for _, tc := range []struct {
+		password string
+		message  string
+	}{
+		{"wrong", "认证失败"},
+		{"", "需要密码"},
+	} {
+		server.SudoPassword = tc.password
+		stderr.Reset()
+		code, err := server.Exec(ExecOptions{Command: "id", Sudo: true, Stderr: &stderr})
+		if code != 1 || err == nil || !strings.Contains(err.Error(), tc.message) {
+			t.Errorf("password %q: code=%d err=%v", tc.password, code, err)
+		}
+		if strings.Contains(stderr.String(), "[autossh-") {
+			t.Errorf("password %q: marker leaked into stderr %q", tc.password, stderr.String())
+		}
+	}
+}
+
+func TestServer_ExecSudoTty(t *testing.T) {
+	installFakeSudo(t)
+
+	server := startTestSSHServer(t)
+	server.SudoPassword = "hunter2"
+
+	// PTY 下提示出现在标准输出中，写入密码后标记被去掉
+	var stdout bytes.Buffer
+	code, err := server.Exec(ExecOptions{
+		Command: "echo ok; exit 4",
+		Tty:     true,
+		Sudo:    true,
+		Stdout:  &stdout,
+	})
+	if err != nil || code != 4 {
+		t.Fatalf("code=%d err=%v stdout=%q", code, err, stdout.String())
+	}
+	if stdout.String() != "ok\n" {
+		t.Errorf("stdout = %q", stdout.String())
+	}
+
+	// 密码错误时不再重试，发送 Ctrl+C 中止 sudo
+	server.SudoPassword = "wrong"
+	stdout.Reset()
+	code, err = server.Exec(ExecOptions{Command: "id", Tty: true, Sudo: true, Stdout: &stdout})
+	if code != 1 || err == nil || !strings.Contains(err.Error(), "认证失败") {
+		t.Errorf("code=%d err=%v", code, err)
+	}
+	if !strings.Contains(stdout.String(), "^C") {
+		t.Errorf("expected sudo to be interrupted, stdout = %q", stdout.String())
+	}
+	if strings.Contains(stdout.String(), "[autossh-") {
+		t.Errorf("marker leaked into stdout %q", stdout.String())
+	}
+}
+
+func TestServer_sudoPassword(t *testing.T) {
+	server := &Server{Password: "login"}
+	if server.sudoPassword() != "" {
+		t.Error("login password must not be reused without opt-in")
+	}
+	server.SudoUseLoginPassword = true
+	if server.sudoPassword() != "login" {
+		t.Error("expected login password")
+	}
+	server.SudoPassword = "sudo"
+	if server.sudoPassword() != "sudo" {
+		t.Error("expected sudo_password to take precedence")
+	}
+}
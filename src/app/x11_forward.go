@@ -0,0 +1,260 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const x11AuthProtocol = "MIT-MAGIC-COOKIE-1"
+
+// 是否启用X11转发，默认不启用
+func (server *Server) getForwardX11() bool {
+	if val, ok := server.Options["ForwardX11"]; ok {
+		if b, ok := toBool(val); ok {
+			return b
+		}
+	}
+	return false
+}
+
+// 本地X11显示
+type x11Display struct {
+	network string
+	address string
+	number  int
+	screen  uint32
+}
+
+// 解析 $DISPLAY，支持 :0、unix:0、host:10.0 以及 macOS 上以 / 开头的套接字路径
+func parseX11Display(display string) (x11Display, error) {
+	if display == "" {
+		return x11Display{}, errors.New("未设置 DISPLAY 环境变量")
+	}
+
+	colon := strings.LastIndex(display, ":")
+	if colon < 0 {
+		return x11Display{}, fmt.Errorf("DISPLAY 格式错误: %s", display)
+	}
+	host, num := display[:colon], display[colon+1:]
+
+	var screen uint64
+	if dot := strings.Index(num, "."); dot >= 0 {
+		var err error
+		if screen, err = strconv.ParseUint(num[dot+1:], 10, 32); err != nil {
+			return x11Display{}, fmt.Errorf("DISPLAY 格式错误: %s", display)
+		}
+		num = num[:dot]
+	}
+	number, err := strconv.Atoi(num)
+	if err != nil || number < 0 {
+		return x11Display{}, fmt.Errorf("DISPLAY 格式错误: %s", display)
+	}
+
+	d := x11Display{number: number, screen: uint32(screen)}
+	switch {
+	case strings.HasPrefix(display, "/"):
+		// launchd 提供的套接字，路径即 DISPLAY 本身
+		d.network, d.address = "unix", display
+	case host == "" || host == "unix":
+		d.network, d.address = "unix", filepath.Join("/tmp/.X11-unix", "X"+num)
+	default:
+		d.network, d.address = "tcp", net.JoinHostPort(host, strconv.Itoa(6000+number))
+	}
+	return d, nil
+}
+
+// 连接上的X11转发，同一连接上的会话共用 x11 通道处理与伪造的 cookie
+type x11Forwarding struct {
+	display    x11Display
+	fakeCookie []byte
+	realCookie []byte
+}
+
+var (
+	// 已注册 x11 通道处理的连接，连接池中的连接会被多个会话复用
+	x11ForwardMutex   sync.Mutex
+	x11ForwardClients = make(map[*ssh.Client]*x11Forwarding)
+)
+
+// 在会话上请求X11转发，服务器打开的 x11 通道转发到本地 $DISPLAY
+// 发给服务器的是随机生成的 cookie，转发时校验并替换为本地 xauth 中的真实 cookie
+func (server *Server) forwardX11(client *ssh.Client, session *ssh.Session) error {
+	fw, err := registerX11Forwarding(client, os.Getenv("DISPLAY"))
+	if err != nil {
+		return err
+	}
+
+	payload := struct {
+		SingleConnection bool
+		AuthProtocol     string
+		AuthCookie       string
+		ScreenNumber     uint32
+	}{
+		AuthProtocol: x11AuthProtocol,
+		AuthCookie:   hex.EncodeToString(fw.fakeCookie),
+		ScreenNumber: fw.display.screen,
+	}
+	ok, err := session.SendRequest("x11-req", true, ssh.Marshal(&payload))
+	if err != nil {
+		return fmt.Errorf("请求 X11 转发失败: %w", err)
+	}
+	if !ok {
+		return errors.New("服务器拒绝 X11 转发（请检查 X11Forwarding 与 xauth 是否可用）")
+	}
+	return nil
+}
+
+// 每个连接只注册一次 x11 通道处理，连接关闭后移除
+func registerX11Forwarding(client *ssh.Client, displayEnv string) (*x11Forwarding, error) {
+	x11ForwardMutex.Lock()
+	defer x11ForwardMutex.Unlock()
+	if fw, ok := x11ForwardClients[client]; ok {
+		return fw, nil
+	}
+
+	display, err := parseX11Display(displayEnv)
+	if err != nil {
+		return nil, err
+	}
+	fakeCookie := make([]byte, 16)
+	if _, err := rand.Read(fakeCookie); err != nil {
+		return nil, fmt.Errorf("生成 X11 cookie 失败: %w", err)
+	}
+
+	channels := client.HandleChannelOpen("x11")
+	if channels == nil {
+		return nil, errors.New("当前连接已注册 X11 转发")
+	}
+
+	fw := &x11Forwarding{display: display, fakeCookie: fakeCookie, realCookie: localX11Cookie(displayEnv)}
+	x11ForwardClients[client] = fw
+	go func() {
+		for ch := range channels {
+			go serveX11Channel(ch, fw.display, fw.fakeCookie, fw.realCookie)
+		}
+	}()
+	go func() {
+		_ = client.Wait()
+		x11ForwardMutex.Lock()
+		delete(x11ForwardClients, client)
+		x11ForwardMutex.Unlock()
+	}()
+	return fw, nil
+}
+
+func serveX11Channel(newChannel ssh.NewChannel, display x11Display, fakeCookie, realCookie []byte) {
+	channel, reqs, err := newChannel.Accept()
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	setup, err := rewriteX11Auth(channel, fakeCookie, realCookie)
+	if err != nil {
+		forwardLogf("X11 连接被拒绝: %v", err)
+		channel.Close()
+		return
+	}
+
+	local, err := net.Dial(display.network, display.address)
+	if err != nil {
+		forwardLogf("连接本地X11显示 %s 失败: %v", display.address, err)
+		channel.Close()
+		return
+	}
+	if _, err := local.Write(setup); err != nil {
+		local.Close()
+		channel.Close()
+		return
+	}
+
+	pipeConn(channel, local)
+}
+
+// 读取X11连接建立请求，校验伪造 cookie 后替换为真实 cookie（无则不带认证信息）
+func rewriteX11Auth(r io.Reader, fakeCookie, realCookie []byte) ([]byte, error) {
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	var order binary.ByteOrder
+	switch header[0] {
+	case 'B':
+		order = binary.BigEndian
+	case 'l':
+		order = binary.LittleEndian
+	default:
+		return nil, fmt.Errorf("未知的字节序标记 %#x", header[0])
+	}
+
+	nameLen := int(order.Uint16(header[6:8]))
+	dataLen := int(order.Uint16(header[8:10]))
+	body := make([]byte, x11Pad(nameLen)+x11Pad(dataLen))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	name := string(body[:nameLen])
+	data := body[x11Pad(nameLen) : x11Pad(nameLen)+dataLen]
+
+	if name != x11AuthProtocol || !bytes.Equal(data, fakeCookie) {
+		return nil, errors.New("认证 cookie 不匹配")
+	}
+
+	var buf bytes.Buffer
+	buf.Write(header[:6])
+	if len(realCookie) == 0 {
+		buf.Write(make([]byte, 6))
+		return buf.Bytes(), nil
+	}
+
+	lengths := make([]byte, 4)
+	order.PutUint16(lengths[0:2], uint16(len(x11AuthProtocol)))
+	order.PutUint16(lengths[2:4], uint16(len(realCookie)))
+	buf.Write(lengths)
+	buf.Write(header[10:12])
+	buf.WriteString(x11AuthProtocol)
+	buf.Write(make([]byte, x11Pad(len(x11AuthProtocol))-len(x11AuthProtocol)))
+	buf.Write(realCookie)
+	buf.Write(make([]byte, x11Pad(len(realCookie))-len(realCookie)))
+	return buf.Bytes(), nil
+}
+
+// X11 协议字段按4字节对齐
+func x11Pad(n int) int {
+	return (n + 3) &^ 3
+}
+
+// 通过 xauth 获取本地显示的真实 cookie，不可用时返回 nil
+func localX11Cookie(display string) []byte {
+	out, err := exec.Command("xauth", "list", display).Output()
+	if err != nil {
+		return nil
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 3 && fields[1] == x11AuthProtocol {
+			if cookie, err := hex.DecodeString(fields[2]); err == nil {
+				return cookie
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package app
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseX11Display(t *testing.T) {
+	cases := []struct {
+		display string
+		network string
+		address string
+		screen  uint32
+	}{
+		{":0", "unix", "/tmp/.X11-unix/X0", 0},
+		{"unix:1.2", "unix", "/tmp/.X11-unix/X1", 2},
+		{"localhost:10.0", "tcp", "localhost:6010", 0},
+		{"/private/tmp/com.apple.launchd.abc/org.xquartz:0", "unix", "/private/tmp/com.apple.launchd.abc/org.xquartz:0", 0},
+	}
+
+	for _, c := range cases {
+		d, err := parseX11Display(c.display)
+		if err != nil {
+			t.Fatalf("%s: %v", c.display, err)
+		}
+		if d.network != c.network || d.address != c.address || d.screen != c.screen {
+			t.Errorf("%s: got %+v", c.display, d)
+		}
+	}
+
+	for _, display := range []string{"", "localhost", ":x"} {
+		if _, err := parseX11Display(display); err == nil {
+			t.Errorf("%q: expected error", display)
+		}
+	}
+}
+
+func x11Setup(name string, data []byte) []byte {
+	var buf bytes.Buffer
+	buf.Write([]byte{'l', 0, 11, 0, 0, 0})
+	_ = binary.Write(&buf, binary.LittleEndian, uint16(len(name)))
+	_ = binary.Write(&buf, binary.LittleEndian, uint16(len(data)))
+	buf.Write([]byte{0, 0})
+	buf.WriteString(name)
+	buf.Write(make([]byte, x11Pad(len(name))-len(name)))
+	buf.Write(data)
+	buf.Write(make([]byte, x11Pad(len(data))-len(data)))
+	return buf.Bytes()
+}
+
+func TestRewriteX11Auth(t *testing.T) {
+	fakeCookie := bytes.Repeat([]byte{1}, 16)
+	realCookie := bytes.Repeat([]byte{2}, 16)
+
+	got, err := rewriteX11Auth(bytes.NewReader(x11Setup(x11AuthProtocol, fakeCookie)), fakeCookie, realCookie)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := x11Setup(x11AuthProtocol, realCookie); !bytes.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	got, err = rewriteX11Auth(bytes.NewReader(x11Setup(x11AuthProtocol, fakeCookie)), fakeCookie, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := x11Setup("", nil); !bytes.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if _, err := rewriteX11Auth(bytes.NewReader(x11Setup(x11AuthProtocol, realCookie)), fakeCookie, realCookie); err == nil {
+		t.Error("expected cookie mismatch error")
+	}
+}
+
+// 连接池中的连接被多个会话复用时，每个会话都能请求X11转发，x11 通道都转发到本地显示
+func TestServer_forwardX11ReusedClient(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "X:0")
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	t.Setenv("DISPLAY", socket)
+
+	server := startTestSSHServer(t)
+	client, err := server.GetSshClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	for i := 0; i < 2; i++ {
+		session, err := client.NewSession()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer session.Close()
+		if err := server.forwardX11(client, session); err != nil {
+			t.Fatalf("session %d: %v", i+1, err)
+		}
+
+		_ = ln.(*net.UnixListener).SetDeadline(time.Now().Add(5 * time.Second))
+		conn, err := ln.Accept()
+		if err != nil {
+			t.Fatalf("session %d: x11 channel not forwarded: %v", i+1, err)
+		}
+		header := make([]byte, 12)
+		_, err = io.ReadFull(conn, header)
+		conn.Close()
+		if err != nil || header[0] != 'l' {
+			t.Errorf("session %d: unexpected setup %v: %v", i+1, header, err)
+		}
+	}
+}
@@ -1,6 +1,9 @@
 package utils
 
-import "fmt"
+import (
+	"fmt"
+	"os"
+)
 
 // 打印一行信息
 // 字体颜色为默色
@@ -30,6 +33,14 @@ func Errorf(format string, args ...interface{}) {
 	Errorln(fmt.Sprintf(format, args...))
 }
 
+// 打印一行错误到标准错误，避免混入命令输出
+// 字体颜色为红色
+func Stderrln(a ...interface{}) {
+	fmt.Fprint(os.Stderr, "\033[31m")
+	fmt.Fprintln(os.Stderr, a...)
+	fmt.Fprint(os.Stderr, "\033[0m")
+}
+
 func Warnf(format string, args ...interface{}) {
 	Logln(fmt.Sprintf(format, args...))
 }
@@ -38,8 +49,9 @@ func Infof(format string, args ...interface{}) {
 	Logln(fmt.Sprintf(format, args...))
 }
 
+// 打印调试信息到标准错误
 func Debugf(format string, args ...interface{}) {
-	Logln(fmt.Sprintf(format, args...))
+	fmt.Fprintln(os.Stderr, fmt.Sprintf(format, args...))
 }
 
 // 二维数组对齐
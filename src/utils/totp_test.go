@@ -0,0 +1,45 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGenerateTOTP(t *testing.T) {
+	// RFC 6238 附录 B 的 SHA1 测试向量（密钥 "12345678901234567890"），取后 6 位
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	cases := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+
+	for ts, want := range cases {
+		got, err := GenerateTOTP(secret, time.Unix(ts, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("GenerateTOTP(%d) = %s, want %s", ts, got, want)
+		}
+	}
+}
+
+func TestGenerateTOTPSecretFormat(t *testing.T) {
+	now := time.Unix(59, 0)
+	want, _ := GenerateTOTP("GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ", now)
+	got, err := GenerateTOTP("gezd gnbv gy3t qojq gezd gnbv gy3t qojq", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	if _, err := GenerateTOTP("not-base32!", now); err == nil {
+		t.Error("expected error for invalid secret")
+	}
+}